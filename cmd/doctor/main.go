@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/doctor"
+	"log"
+	"os"
+)
+
+// doctor scans user refs and embedded bourbons for drift and prints a report
+// usage: go run ./cmd/doctor [-fix] [-json]
+func main() {
+	fix := flag.Bool("fix", false, "repair every fixable issue while scanning")
+	asJSON := flag.Bool("json", false, "print the report as json")
+	flag.Parse()
+
+	report, err := doctor.Run(context.Background(), doctor.Collections{
+		Users:       db.GetCollection(db.DB, "users"),
+		Bourbons:    db.GetCollection(db.DB, "bourbons"),
+		Collections: db.GetCollection(db.DB, "collections"),
+		Wishlists:   db.GetCollection(db.DB, "wishlists"),
+		Reviews:     db.GetCollection(db.DB, "reviews"),
+	}, *fix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+	if len(report.Issues) > 0 && !*fix {
+		os.Exit(1)
+	}
+}
@@ -1,22 +1,48 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	appHandlers "github.com/GoloisaNinja/go-bourbon-api/pkg/handlers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/middleware"
 	"github.com/gorilla/handlers"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout is how long requests in flight and the last usage flush get
+// once the server is told to stop
+var shutdownTimeout = helpers.EnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second)
+
 func main() {
 	// Connection mongoDB
 	db.ConnectDB()
+	// a failed index is logged and not fatal - duplicates already in the
+	// data have to be cleaned up before a unique index can be built
+	if err := appHandlers.EnsureIndexes(context.TODO()); err != nil {
+		log.Printf("indexes not created: %v", err)
+	}
+	// keys from before scopes are refused until they are granted some
+	if n, err := appHandlers.MigrateKeyScopes(context.TODO()); err != nil {
+		log.Printf("api key scopes not migrated: %v", err)
+	} else if n > 0 {
+		log.Printf("granted scopes to %d api keys that had none", n)
+	}
+	if err := middleware.EnsureIndexes(context.TODO()); err != nil {
+		log.Printf("rate limit indexes not created: %v", err)
+	}
 
 	// Optional Initial Seed of Db
 	//data.SeedDBRecords()
 	// cors
-	headersOk := handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Authorization", "Bearer", "Accept", "Accept-Language", "Origin", "Accept-Encoding", "Content-Length", "Referrer", "User-Agent"})
+	headersOk := handlers.AllowedHeaders([]string{"Content-Type", "X-Requested-With", "Authorization", "Bearer", "X-API-Key", "Accept", "Accept-Language", "Origin", "Accept-Encoding", "Content-Length", "Referrer", "User-Agent", "If-Match", "If-None-Match", "If-Modified-Since"})
+	exposedOk := handlers.ExposedHeaders([]string{"ETag", "Last-Modified", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"})
 	originOk := handlers.AllowedOrigins([]string{"https://hellogobourbon.netlify.app", "http://localhost:3000"})
 	methodsOk := handlers.AllowedMethods([]string{"PUT", "POST", "GET", "DELETE", "OPTIONS"})
 	//set port
@@ -27,11 +53,28 @@ func main() {
 	// bring in the routes to serve
 	srv := &http.Server{
 		Addr:    port,
-		Handler: handlers.CORS(originOk, headersOk, methodsOk)(routes()),
+		Handler: handlers.CORS(originOk, headersOk, methodsOk, exposedOk)(routes()),
 	}
 
-	fmt.Println("Server is up on port 10000")
-	err := srv.ListenAndServe()
-	log.Fatal(err)
-
+	// SIGINT or SIGTERM stops taking requests and lets the ones in flight
+	// finish before the buffered key usage is written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		fmt.Println("Server is up on port " + port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+	stop()
+	fmt.Println("Server is shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if err := middleware.FlushUsage(shutdownCtx); err != nil {
+		log.Printf("key usage not written: %v", err)
+	}
 }
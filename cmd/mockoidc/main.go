@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/golang-jwt/jwt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mockoidc is a local openid connect issuer for trying provider logins
+// without a real provider. Every authorize request is approved straight away
+// for the user given by the flags - email, sub, name and email_verified query
+// params on the authorize url override them per login.
+// usage: go run ./cmd/mockoidc [-addr :9090] [-issuer http://localhost:9090]
+//
+// point the api at it with
+//
+//	OIDC_PROVIDERS=mock
+//	OIDC_MOCK_ISSUER=http://localhost:9090
+//	OIDC_MOCK_CLIENT_ID=hellobourbon
+//	OIDC_MOCK_REDIRECT_URL=<the callback the frontend or api listens on>
+
+const keyID = "mock-1"
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      jwt.MapClaims
+	expires     time.Time
+}
+
+type issuer struct {
+	url          string
+	clientID     string
+	clientSecret string
+	defaults     jwt.MapClaims
+	key          *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+func main() {
+	addr := flag.String("addr", ":9090", "address to listen on")
+	issuerURL := flag.String("issuer", "http://localhost:9090", "issuer url - must match OIDC_<NAME>_ISSUER")
+	clientID := flag.String("client-id", "hellobourbon", "client id the api uses")
+	clientSecret := flag.String("client-secret", "", "client secret the api must send - empty for a public client")
+	email := flag.String("email", "mock.user@example.com", "email of the logged in user")
+	sub := flag.String("sub", "mock-user-1", "subject of the logged in user")
+	name := flag.String("name", "Mock User", "name of the logged in user")
+	verified := flag.Bool("email-verified", true, "whether the email is reported as verified")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	iss := &issuer{
+		url:          strings.TrimSuffix(*issuerURL, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		defaults: jwt.MapClaims{
+			"sub":                *sub,
+			"email":              *email,
+			"email_verified":     *verified,
+			"name":               *name,
+			"preferred_username": strings.Split(*email, "@")[0],
+		},
+		key:    key,
+		grants: make(map[string]*grant),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("/authorize", iss.authorize)
+	mux.HandleFunc("/token", iss.token)
+	mux.HandleFunc("/jwks", iss.jwks)
+	fmt.Println("mock oidc issuer is up at " + iss.url)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func oauthError(w http.ResponseWriter, status int, code, desc string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": desc})
+}
+
+func (iss *issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]interface{}{
+		"issuer":                                iss.url,
+		"authorization_endpoint":                iss.url + "/authorize",
+		"token_endpoint":                        iss.url + "/token",
+		"jwks_uri":                              iss.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != iss.clientID || redirectURI == "" {
+		oauthError(w, 400, "invalid_request", "unknown client or missing redirect_uri")
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		oauthError(w, 400, "invalid_request", "only the code flow with an S256 pkce challenge is supported")
+		return
+	}
+	claims := jwt.MapClaims{}
+	for k, v := range iss.defaults {
+		claims[k] = v
+	}
+	for _, k := range []string{"sub", "email", "name"} {
+		if v := q.Get(k); v != "" {
+			claims[k] = v
+		}
+	}
+	if v := q.Get("email_verified"); v != "" {
+		claims["email_verified"] = v == "true"
+	}
+	code, err := helpers.GenerateRandomToken(24)
+	if err != nil {
+		oauthError(w, 500, "server_error", err.Error())
+		return
+	}
+	iss.mu.Lock()
+	iss.grants[code] = &grant{
+		clientID:    iss.clientID,
+		redirectURI: redirectURI,
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+		claims:      claims,
+		expires:     time.Now().Add(time.Minute),
+	}
+	iss.mu.Unlock()
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		oauthError(w, 400, "invalid_request", "bad redirect_uri")
+		return
+	}
+	bq := back.Query()
+	bq.Set("code", code)
+	bq.Set("state", q.Get("state"))
+	back.RawQuery = bq.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (iss *issuer) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.ParseForm() != nil {
+		oauthError(w, 400, "invalid_request", "expected a form post")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, 400, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != iss.clientID || (iss.clientSecret != "" && secret != iss.clientSecret) {
+		oauthError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	// codes are single use
+	code := r.PostForm.Get("code")
+	iss.mu.Lock()
+	g := iss.grants[code]
+	delete(iss.grants, code)
+	iss.mu.Unlock()
+	if g == nil || time.Now().After(g.expires) || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		oauthError(w, 400, "invalid_grant", "code is invalid, expired or for another redirect_uri")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(g.challenge)) != 1 {
+		oauthError(w, 400, "invalid_grant", "code_verifier does not match the challenge")
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   iss.url,
+		"aud":   g.clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": g.nonce,
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(iss.key)
+	if err != nil {
+		oauthError(w, 500, "server_error", err.Error())
+		return
+	}
+	accessToken, _ := helpers.GenerateRandomToken(24)
+	writeJSON(w, 200, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (iss *issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	writeJSON(w, 200, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/apikey"
 	appHandlers "github.com/GoloisaNinja/go-bourbon-api/pkg/handlers"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/middleware"
 	"github.com/gorilla/mux"
@@ -29,6 +30,27 @@ func routes() http.Handler {
 	createNewUser := http.HandlerFunc(appHandlers.CreateUser)
 	loginUser := http.HandlerFunc(appHandlers.LoginUser)
 	logoutUserHandler := http.HandlerFunc(appHandlers.LogoutUser)
+	exportUserData := http.HandlerFunc(appHandlers.ExportUserData)
+	deleteUser := http.HandlerFunc(appHandlers.DeleteUser)
+	refreshSession := http.HandlerFunc(appHandlers.RefreshSession)
+	forgotPassword := http.HandlerFunc(appHandlers.ForgotPassword)
+	resetPassword := http.HandlerFunc(appHandlers.ResetPassword)
+	requestEmailVerification := http.HandlerFunc(appHandlers.RequestEmailVerification)
+	verifyEmail := http.HandlerFunc(appHandlers.VerifyEmail)
+	changePassword := http.HandlerFunc(appHandlers.ChangePassword)
+	changeEmail := http.HandlerFunc(appHandlers.ChangeEmail)
+	changeUsername := http.HandlerFunc(appHandlers.ChangeUsername)
+	completeTwoFactorLogin := http.HandlerFunc(appHandlers.CompleteTwoFactorLogin)
+	enrollTwoFactor := http.HandlerFunc(appHandlers.EnrollTwoFactor)
+	confirmTwoFactor := http.HandlerFunc(appHandlers.ConfirmTwoFactor)
+	regenerateRecoveryCodes := http.HandlerFunc(appHandlers.RegenerateRecoveryCodes)
+	disableTwoFactor := http.HandlerFunc(appHandlers.DisableTwoFactor)
+	getOIDCProviders := http.HandlerFunc(appHandlers.GetOIDCProviders)
+	startOIDCLogin := http.HandlerFunc(appHandlers.StartOIDCLogin)
+	oidcCallback := http.HandlerFunc(appHandlers.OIDCCallback)
+	getSessions := http.HandlerFunc(appHandlers.GetSessions)
+	revokeSession := http.HandlerFunc(appHandlers.RevokeSession)
+	revokeAllSessions := http.HandlerFunc(appHandlers.RevokeAllSessions)
 
 	// base database collection type appHandlers. for collections and wishlists
 	// appHandlers. manage both database collection document types by extracting a cType from router params
@@ -38,6 +60,42 @@ func routes() http.Handler {
 	updateCollection := http.HandlerFunc(appHandlers.UpdateCollection)
 	deleteCollection := http.HandlerFunc(appHandlers.DeleteCollection)
 	updateBourbonsToCollection := http.HandlerFunc(appHandlers.UpdateBourbonsInCollection)
+	acquireBourbon := http.HandlerFunc(appHandlers.AcquireBourbon)
+	reorderCollectionBourbons := http.HandlerFunc(appHandlers.ReorderCollectionBourbons)
+	updateCollectionEntry := http.HandlerFunc(appHandlers.UpdateCollectionEntry)
+	cloneCollection := http.HandlerFunc(appHandlers.CloneCollection)
+	mergeCollections := http.HandlerFunc(appHandlers.MergeCollections)
+	splitCollection := http.HandlerFunc(appHandlers.SplitCollection)
+	bulkUpdateBourbonsToCollection := http.HandlerFunc(appHandlers.BulkUpdateBourbonsInCollection)
+
+	// public and share link appHandlers. for collections and wishlists
+	getPublicCollectionsType := http.HandlerFunc(appHandlers.GetPublicCollectionsType)
+	getPublicCollectionTypeById := http.HandlerFunc(appHandlers.GetPublicCollectionTypeById)
+	getSharedCollectionType := http.HandlerFunc(appHandlers.GetSharedCollectionType)
+	createShareLink := http.HandlerFunc(appHandlers.CreateShareLink)
+	revokeShareLink := http.HandlerFunc(appHandlers.RevokeShareLink)
+
+	// member appHandlers. for shared collections and wishlists
+	inviteCollectionMember := http.HandlerFunc(appHandlers.InviteCollectionMember)
+	acceptCollectionInvite := http.HandlerFunc(appHandlers.AcceptCollectionInvite)
+	updateCollectionMemberRole := http.HandlerFunc(appHandlers.UpdateCollectionMemberRole)
+	removeCollectionMember := http.HandlerFunc(appHandlers.RemoveCollectionMember)
+	getCollectionInvites := http.HandlerFunc(appHandlers.GetCollectionInvites)
+
+	// import appHandlers.
+	previewImport := http.HandlerFunc(appHandlers.PreviewImport)
+	confirmImport := http.HandlerFunc(appHandlers.ConfirmImport)
+
+	// admin appHandlers.
+	runDoctor := http.HandlerFunc(appHandlers.RunDoctor)
+	createAPIKey := http.HandlerFunc(appHandlers.CreateAPIKey)
+	getAPIKeys := http.HandlerFunc(appHandlers.GetAPIKeys)
+	rotateAPIKey := http.HandlerFunc(appHandlers.RotateAPIKey)
+	revokeAPIKey := http.HandlerFunc(appHandlers.RevokeAPIKey)
+	updateAPIKeyLimits := http.HandlerFunc(appHandlers.UpdateAPIKeyLimits)
+	updateAPIKeyScopes := http.HandlerFunc(appHandlers.UpdateAPIKeyScopes)
+	getKeyUsage := http.HandlerFunc(appHandlers.GetKeyUsage)
+	clearCaches := http.HandlerFunc(appHandlers.ClearCaches)
 
 	// review appHandlers.
 	getReviewById := http.HandlerFunc(appHandlers.GetReviewById)
@@ -46,57 +104,171 @@ func routes() http.Handler {
 	deleteReview := http.HandlerFunc(appHandlers.DeleteReview)
 	updateReview := http.HandlerFunc(appHandlers.UpdateReview)
 
-	// define routes
+	// define routes - the scopes passed to ApiAuth are what an api key needs
+	// to reach the route
 
 	// **bourbon routes**
 	// get paginated bourbons
-	r.Handle("/api/bourbons", middleware.ApiAuth(getBourbons)).Methods("GET")
+	r.Handle("/api/bourbons", middleware.ApiAuth(getBourbons, apikey.ScopeCatalogRead)).Methods("GET")
 	// get a randomized bourbon
 	r.Handle(
-		"/api/bourbons/random", middleware.ApiAuth(getRandomBourbon),
+		"/api/bourbons/random", middleware.ApiAuth(getRandomBourbon, apikey.ScopeCatalogRead),
 	).Methods("GET")
 	// get a bourbon by id
-	r.Handle("/api/bourbons/{id}", middleware.ApiAuth(getBourbonById)).Methods("GET")
+	r.Handle("/api/bourbons/{id}", middleware.ApiAuth(getBourbonById, apikey.ScopeCatalogRead)).Methods("GET")
 
 	// **user routes**
 	// create a new user
-	r.Handle("/api/user", middleware.ApiAuth(middleware.Register(createNewUser))).Methods("POST")
+	r.Handle("/api/user", middleware.ApiAuth(middleware.Register(createNewUser), apikey.ScopeUsersWrite)).Methods("POST")
 	// login an existing user
-	r.Handle("/api/user/login", middleware.ApiAuth(loginUser)).Methods("POST")
+	r.Handle("/api/user/login", middleware.ApiAuth(loginUser, apikey.ScopeUsersWrite)).Methods("POST")
 	// logout a user
-	r.Handle("/api/user/logout", middleware.ApiAuth(middleware.Auth(logoutUserHandler))).Methods("POST")
+	r.Handle("/api/user/logout", middleware.ApiAuth(middleware.Auth(logoutUserHandler), apikey.ScopeUsersWrite)).Methods("POST")
+	// finish a login with 2fa on - takes the challenge token from login and a code
+	r.Handle("/api/user/login/2fa", middleware.ApiAuth(completeTwoFactorLogin, apikey.ScopeUsersWrite)).Methods("POST")
+	// start 2fa setup - responds with the secret and otpauth uri
+	r.Handle("/api/user/2fa/enroll", middleware.ApiAuth(middleware.Auth(enrollTwoFactor), apikey.ScopeUsersWrite)).Methods("POST")
+	// turn 2fa on with a code from the new secret - responds with recovery codes
+	r.Handle("/api/user/2fa/confirm", middleware.ApiAuth(middleware.Auth(confirmTwoFactor), apikey.ScopeUsersWrite)).Methods("POST")
+	// replace the recovery codes of the auth user
+	r.Handle("/api/user/2fa/recovery-codes", middleware.ApiAuth(middleware.Auth(regenerateRecoveryCodes), apikey.ScopeUsersWrite)).Methods("POST")
+	// turn 2fa off - needs the password and a code
+	r.Handle("/api/user/2fa/disable", middleware.ApiAuth(middleware.Auth(disableTwoFactor), apikey.ScopeUsersWrite)).Methods("POST")
+	// list the external oidc providers users can log in with
+	r.Handle("/api/auth/oidc", middleware.ApiAuth(getOIDCProviders, apikey.ScopeUsersRead)).Methods("GET")
+	// start a provider login - responds with the url to send the browser to
+	r.Handle("/api/auth/oidc/{provider}/start", middleware.ApiAuth(startOIDCLogin, apikey.ScopeUsersWrite)).Methods("GET")
+	// finish a provider login with the code and state sent back by the provider
+	r.Handle("/api/auth/oidc/{provider}/callback", middleware.ApiAuth(oidcCallback, apikey.ScopeUsersWrite)).Methods("GET")
+	// swap a refresh token for a new access and refresh token
+	r.Handle("/api/user/refresh", middleware.ApiAuth(refreshSession, apikey.ScopeUsersWrite)).Methods("POST")
+	// mail a password reset link - responds the same whether or not the email exists
+	r.Handle("/api/user/password/forgot", middleware.ApiAuth(forgotPassword, apikey.ScopeUsersWrite)).Methods("POST")
+	// set a new password with a reset token - revokes every session
+	r.Handle("/api/user/password/reset", middleware.ApiAuth(resetPassword, apikey.ScopeUsersWrite)).Methods("POST")
+	// mail a new email verification link to the auth user
+	r.Handle("/api/user/verify/request", middleware.ApiAuth(middleware.Auth(requestEmailVerification), apikey.ScopeUsersWrite)).Methods("POST")
+	// verify an email with a verification token
+	r.Handle("/api/user/verify", middleware.ApiAuth(verifyEmail, apikey.ScopeUsersWrite)).Methods("POST")
+	// change the password of the auth user - revokes every other session
+	r.Handle("/api/user/password", middleware.ApiAuth(middleware.Auth(changePassword), apikey.ScopeUsersWrite)).Methods("POST")
+	// change the email of the auth user - the new email must be verified again
+	r.Handle("/api/user/email", middleware.ApiAuth(middleware.Auth(changeEmail), apikey.ScopeUsersWrite)).Methods("POST")
+	// change the username of the auth user everywhere it is referenced
+	r.Handle("/api/user/username", middleware.ApiAuth(middleware.Auth(changeUsername), apikey.ScopeUsersWrite)).Methods("POST")
+	// list the active sessions of the auth user
+	r.Handle("/api/user/sessions", middleware.ApiAuth(middleware.Auth(getSessions), apikey.ScopeUsersRead)).Methods("GET")
+	// revoke every session of the auth user - others=true keeps the current one
+	r.Handle("/api/user/sessions", middleware.ApiAuth(middleware.Auth(revokeAllSessions), apikey.ScopeUsersWrite)).Methods("DELETE")
+	// revoke one session of the auth user
+	r.Handle("/api/user/sessions/{sessionId}", middleware.ApiAuth(middleware.Auth(revokeSession), apikey.ScopeUsersWrite)).Methods("DELETE")
+	// download a zip of everything stored about the auth user
+	r.Handle("/api/user/export", middleware.ApiAuth(middleware.Auth(exportUserData), apikey.ScopeUsersRead)).Methods("GET")
+	// delete the auth user - the password must be sent again
+	r.Handle("/api/user", middleware.ApiAuth(middleware.Auth(deleteUser), apikey.ScopeUsersWrite)).Methods("DELETE")
 
 	// review routes
 	// create a review
-	r.Handle("/api/review", middleware.ApiAuth(middleware.Auth(createReview))).Methods("POST")
+	r.Handle("/api/review", middleware.ApiAuth(middleware.Auth(createReview), apikey.ScopeReviewsWrite)).Methods("POST")
 	// get a single review by id
-	r.Handle("/api/review/{id}", middleware.ApiAuth(getReviewById)).Methods("GET")
+	r.Handle("/api/review/{id}", middleware.ApiAuth(getReviewById, apikey.ScopeReviewsRead)).Methods("GET")
 	// get all reviews by a filter type (either by bourbon id or by user id)
-	r.Handle("/api/reviews/{fType}/{id}", middleware.ApiAuth(getAllReviewsByFilterId)).Methods("GET")
+	r.Handle("/api/reviews/{fType}/{id}", middleware.ApiAuth(getAllReviewsByFilterId, apikey.ScopeReviewsRead)).Methods("GET")
 	// delete a review by id - auth route - user requesting delete must be owner of review
-	r.Handle("/api/review/delete/{id}", middleware.ApiAuth(middleware.Auth(deleteReview))).Methods("DELETE")
+	r.Handle("/api/review/delete/{id}", middleware.ApiAuth(middleware.Auth(deleteReview), apikey.ScopeReviewsWrite)).Methods("DELETE")
 	// update a single review
-	r.Handle("/api/review/update/{id}", middleware.ApiAuth(middleware.Auth(updateReview))).Methods("POST")
+	r.Handle("/api/review/update/{id}", middleware.ApiAuth(middleware.Auth(updateReview), apikey.ScopeReviewsWrite)).Methods("POST")
 
 	// **database collections routes (collection & wishlist cTypes)**
 	// create a new collection or wishlist based on cType param
 	r.Handle(
-		"/api/type/{cType}", middleware.ApiAuth(middleware.Auth(createCollection)),
+		"/api/type/{cType}", middleware.ApiAuth(middleware.Auth(createCollection), apikey.ScopeCollectionsWrite),
 	).Methods("POST")
 	// get a collection or wishlist collection by id based on cType param
-	r.Handle("/api/type/{cType}/{id}", middleware.ApiAuth(middleware.Auth(getCollectionTypeById))).Methods("GET")
+	r.Handle("/api/type/{cType}/{id}", middleware.ApiAuth(middleware.Auth(getCollectionTypeById), apikey.ScopeCollectionsRead)).Methods("GET")
 	// get a slice of collections or wishlists based on the cType param the auth user making the request
-	r.Handle("/api/type/{cType}", middleware.ApiAuth(middleware.Auth(getAllCollectionsType))).Methods("GET")
+	r.Handle("/api/type/{cType}", middleware.ApiAuth(middleware.Auth(getAllCollectionsType), apikey.ScopeCollectionsRead)).Methods("GET")
 	// delete an existing collection or wishlist based on cType param
 	r.Handle(
-		"/api/type/{cType}/{id}", middleware.ApiAuth(middleware.Auth(deleteCollection)),
+		"/api/type/{cType}/{id}", middleware.ApiAuth(middleware.Auth(deleteCollection), apikey.ScopeCollectionsWrite),
 	).Methods("DELETE")
 	// update an existing collection or wishlist name and private flag based on cType param
-	r.Handle("/api/type/{cType}/update/{id}", middleware.ApiAuth(middleware.Auth(updateCollection))).Methods("POST")
+	r.Handle("/api/type/{cType}/update/{id}", middleware.ApiAuth(middleware.Auth(updateCollection), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// reorder the bourbons in a collection or wishlist
+	r.Handle("/api/type/{cType}/reorder/{id}", middleware.ApiAuth(middleware.Auth(reorderCollectionBourbons), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// add or delete many bourbons in a collection or wishlist in one batch
+	r.Handle("/api/type/{cType}/bulk/{id}", middleware.ApiAuth(middleware.Auth(bulkUpdateBourbonsToCollection), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// duplicate a collection or wishlist the auth user can read into a new list
+	r.Handle("/api/type/{cType}/clone/{id}", middleware.ApiAuth(middleware.Auth(cloneCollection), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// move selected bourbons out of a list into a new list
+	r.Handle("/api/type/{cType}/split/{id}", middleware.ApiAuth(middleware.Auth(splitCollection), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// merge the source list into the target list - registered before the add/delete route
+	r.Handle(
+		"/api/type/{cType}/merge/{id}/{sourceId}", middleware.ApiAuth(middleware.Auth(mergeCollections), apikey.ScopeCollectionsWrite),
+	).Methods("POST")
+	// set the tags and notes of a bourbon entry - registered before the add/delete
+	// route below which would otherwise match the entry placeholder as an action
+	r.Handle(
+		"/api/type/{cType}/entry/{collectionId}/{bourbonId}", middleware.ApiAuth(middleware.Auth(updateCollectionEntry), apikey.ScopeCollectionsWrite),
+	).Methods("POST")
 	// add or delete a bourbon by id into a collection and a usercollectionref
 	// add or delete determined by action placeholder in route as well as cType router param
 	r.Handle(
-		"/api/type/{cType}/{action}/{collectionId}/{bourbonId}", middleware.ApiAuth(middleware.Auth(updateBourbonsToCollection))).Methods("POST", "DELETE")
+		"/api/type/{cType}/{action}/{collectionId}/{bourbonId}", middleware.ApiAuth(middleware.Auth(updateBourbonsToCollection), apikey.ScopeCollectionsWrite)).Methods("POST", "DELETE")
+	// move a bourbon from a wishlist into a collection in a single transaction
+	r.Handle(
+		"/api/acquire/{wishlistId}/{bourbonId}/{collectionId}", middleware.ApiAuth(middleware.Auth(acquireBourbon), apikey.ScopeCollectionsWrite),
+	).Methods("POST")
+
+	// **public and shared collection routes**
+	// browse the public collections or wishlists (plural cType) of all users - paginated
+	r.Handle("/api/public/{cType}", middleware.ApiAuth(getPublicCollectionsType, apikey.ScopeCollectionsRead)).Methods("GET")
+	// get a single public collection or wishlist by id
+	r.Handle("/api/public/{cType}/{id}", middleware.ApiAuth(getPublicCollectionTypeById, apikey.ScopeCollectionsRead)).Methods("GET")
+	// get a collection or wishlist by share link token - works for private lists
+	r.Handle("/api/shared/{cType}/{token}", middleware.ApiAuth(getSharedCollectionType, apikey.ScopeCollectionsRead)).Methods("GET")
+	// create a share link for a collection or wishlist - auth user must be the owner
+	r.Handle("/api/share/{cType}/{id}", middleware.ApiAuth(middleware.Auth(createShareLink), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// revoke a share link - auth user must be the owner
+	r.Handle("/api/share/{cType}/{id}/{token}", middleware.ApiAuth(middleware.Auth(revokeShareLink), apikey.ScopeCollectionsWrite)).Methods("DELETE")
+
+	// **collection member routes**
+	// get the pending invites (plural cType) of the auth user
+	r.Handle("/api/invites/{cType}", middleware.ApiAuth(middleware.Auth(getCollectionInvites), apikey.ScopeCollectionsRead)).Methods("GET")
+	// invite a user by username with a role - auth user must be an owner
+	r.Handle("/api/members/{cType}/{id}", middleware.ApiAuth(middleware.Auth(inviteCollectionMember), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// accept a pending invite for the auth user - registered before the role route
+	r.Handle("/api/members/{cType}/{id}/accept", middleware.ApiAuth(middleware.Auth(acceptCollectionInvite), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// change the role of a member - auth user must be an owner
+	r.Handle("/api/members/{cType}/{id}/{userId}", middleware.ApiAuth(middleware.Auth(updateCollectionMemberRole), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// remove a member - owners can remove anyone, members can remove themselves
+	r.Handle("/api/members/{cType}/{id}/{userId}", middleware.ApiAuth(middleware.Auth(removeCollectionMember), apikey.ScopeCollectionsWrite)).Methods("DELETE")
+
+	// **import routes**
+	// match the rows of an uploaded csv against the catalog - nothing is saved
+	r.Handle("/api/import/preview", middleware.ApiAuth(middleware.Auth(previewImport), apikey.ScopeCollectionsWrite)).Methods("POST")
+	// create a collection or wishlist from the confirmed rows of a preview
+	r.Handle("/api/import/confirm", middleware.ApiAuth(middleware.Auth(confirmImport), apikey.ScopeCollectionsWrite)).Methods("POST")
+
+	// **admin routes** - auth user must be flagged as an admin
+	// scan user refs and embedded bourbons for drift - GET reports, POST also fixes
+	r.Handle("/api/admin/doctor", middleware.ApiAuth(middleware.Auth(middleware.Admin(runDoctor)), apikey.ScopeAdmin)).Methods("GET", "POST")
+	// create an api key - the secret is only in this response
+	r.Handle("/api/admin/keys", middleware.ApiAuth(middleware.Auth(middleware.Admin(createAPIKey)), apikey.ScopeAdmin)).Methods("POST")
+	// list api keys without their secrets
+	r.Handle("/api/admin/keys", middleware.ApiAuth(middleware.Auth(middleware.Admin(getAPIKeys)), apikey.ScopeAdmin)).Methods("GET")
+	// give an api key a new secret - the old one stops working
+	r.Handle("/api/admin/keys/{id}/rotate", middleware.ApiAuth(middleware.Auth(middleware.Admin(rotateAPIKey)), apikey.ScopeAdmin)).Methods("POST")
+	// set the rate limits and daily quota of an api key
+	r.Handle("/api/admin/keys/{id}/limits", middleware.ApiAuth(middleware.Auth(middleware.Admin(updateAPIKeyLimits)), apikey.ScopeAdmin)).Methods("PUT")
+	// replace the scopes of an api key
+	r.Handle("/api/admin/keys/{id}/scopes", middleware.ApiAuth(middleware.Auth(middleware.Admin(updateAPIKeyScopes)), apikey.ScopeAdmin)).Methods("PUT")
+	// revoke an api key
+	r.Handle("/api/admin/keys/{id}", middleware.ApiAuth(middleware.Auth(middleware.Admin(revokeAPIKey)), apikey.ScopeAdmin)).Methods("DELETE")
+	// requests per api key, route and status over a time range
+	r.Handle("/api/admin/usage", middleware.ApiAuth(middleware.Auth(middleware.Admin(getKeyUsage)), apikey.ScopeAdmin)).Methods("GET")
+	// drop the cached catalog reads and api key lookups of this instance
+	r.Handle("/api/admin/cache/clear", middleware.ApiAuth(middleware.Auth(middleware.Admin(clearCaches)), apikey.ScopeAdmin)).Methods("POST")
 
 	return r
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/usage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"os"
+	"time"
+)
+
+// usage prints how many requests each api key made per route and status
+// usage: go run ./cmd/usage [-since 168h] [-key <id>] [-hourly] [-json]
+func main() {
+	since := flag.Duration("since", 7*24*time.Hour, "how far back to report")
+	key := flag.String("key", "", "only report this api key id")
+	hourly := flag.Bool("hourly", false, "keep the hours apart")
+	asJSON := flag.Bool("json", false, "print the report as json")
+	flag.Parse()
+
+	now := time.Now()
+	q := usage.Query{From: now.Add(-*since), To: now, Hourly: *hourly}
+	if *key != "" {
+		id, err := primitive.ObjectIDFromHex(*key)
+		if err != nil {
+			log.Fatal("invalid key id")
+		}
+		q.KeyID = id
+	}
+	report, err := usage.Run(context.Background(), q)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		report.Print(os.Stdout)
+	}
+}
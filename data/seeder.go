@@ -3,6 +3,7 @@ package data
 import (
 	"context"
 	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/cache"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
 	"go.mongodb.org/mongo-driver/bson"
 	"log"
@@ -17755,5 +17756,7 @@ func SeedDBRecords() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// cached bourbon reads no longer match the catalog
+	cache.Catalog.Clear()
 	fmt.Printf("Success! Added %d records!", len(result.InsertedIDs))
 }
@@ -0,0 +1,74 @@
+// Package apikey generates api key secrets and reads them off requests. Only
+// the sha256 of a secret is ever stored
+package apikey
+
+import (
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"net/http"
+	"strings"
+)
+
+// Header is where clients should send their key - the apiKey query param is
+// still read for older clients but ends up in urls and logs
+const Header = "X-API-Key"
+
+// secretPrefix marks new keys so they are easy to spot in code and configs
+const secretPrefix = "hb_"
+
+// prefixLen is how much of a secret is kept in the clear to tell keys apart
+const prefixLen = len(secretPrefix) + 8
+
+// Generate returns a new secret with its display prefix and its hash
+func Generate() (secret, prefix, hash string, err error) {
+	token, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	secret = secretPrefix + token
+	return secret, secret[:prefixLen], Hash(secret), nil
+}
+
+// Hash is the form a secret is stored and looked up in
+func Hash(secret string) string {
+	return helpers.HashToken(secret)
+}
+
+// FromRequest returns the key sent with a request, preferring the header
+func FromRequest(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get(Header)); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("apiKey")
+}
+
+// scopes a key can be granted - every route declares the scope it needs
+const (
+	ScopeCatalogRead      = "catalog:read"
+	ScopeReviewsRead      = "reviews:read"
+	ScopeReviewsWrite     = "reviews:write"
+	ScopeUsersRead        = "users:read"
+	ScopeUsersWrite       = "users:write"
+	ScopeCollectionsRead  = "collections:read"
+	ScopeCollectionsWrite = "collections:write"
+	ScopeAdmin            = "admin"
+)
+
+var Scopes = []string{
+	ScopeCatalogRead,
+	ScopeReviewsRead,
+	ScopeReviewsWrite,
+	ScopeUsersRead,
+	ScopeUsersWrite,
+	ScopeCollectionsRead,
+	ScopeCollectionsWrite,
+	ScopeAdmin,
+}
+
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+// Package cache keeps the results of hot reads. Values are stored as bytes so
+// a cache shared between instances can implement Cache later - until then
+// every instance has its own LRU and writes made by another instance are only
+// seen once the entries expire
+package cache
+
+import (
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+type Cache interface {
+	// Get returns the value of a key that has not expired
+	Get(key string) ([]byte, bool)
+	// Set stores a value for ttl
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+	// Clear drops every entry
+	Clear()
+}
+
+// the caches shared by the handlers and the middleware - Catalog holds
+// bourbon reads and Keys holds api key lookups
+var (
+	Catalog Cache = NewLRU(helpers.EnvInt("CACHE_CATALOG_SIZE", 1000))
+	Keys    Cache = NewLRU(helpers.EnvInt("CACHE_KEYS_SIZE", 1000))
+)
+
+// GetBSON decodes a cached document into v and reports whether there was one
+func GetBSON(c Cache, key string, v interface{}) bool {
+	b, ok := c.Get(key)
+	if !ok {
+		return false
+	}
+	return bson.Unmarshal(b, v) == nil
+}
+
+// SetBSON caches v as a bson document - v must marshal to a document, so
+// slices have to be wrapped in a struct
+func SetBSON(c Cache, key string, v interface{}, ttl time.Duration) error {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	c.Set(key, b, ttl)
+	return nil
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), time.Hour)
+	c.Set("b", []byte("2"), time.Hour)
+	// reading a makes b the least recently used
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("a missing before the cache was full")
+	}
+	c.Set("c", []byte("3"), time.Hour)
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "1", true},
+		{"b", "", false},
+		{"c", "3", true},
+	}
+	for _, tt := range tests {
+		got, ok := c.Get(tt.key)
+		if ok != tt.ok || string(got) != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", tt.key, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLRUOverwrite(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", []byte("1"), time.Hour)
+	c.Set("b", []byte("2"), time.Hour)
+	// overwriting a keeps one entry for it and makes it the most recently used
+	c.Set("a", []byte("3"), time.Hour)
+	c.Set("c", []byte("4"), time.Hour)
+	if got, ok := c.Get("a"); !ok || string(got) != "3" {
+		t.Errorf("Get(a) = %q, %v, want the new value", got, ok)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Error("b was not evicted")
+	}
+	if n := c.order.Len(); n != 2 {
+		t.Errorf("cache holds %d entries, want 2", n)
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("live", []byte("1"), time.Hour)
+	c.Set("expired", []byte("2"), -time.Second)
+	if _, ok := c.Get("live"); !ok {
+		t.Error("live entry missing")
+	}
+	if _, ok := c.Get("expired"); ok {
+		t.Error("expired entry returned")
+	}
+	// reading an expired entry drops it
+	if _, ok := c.entries["expired"]; ok {
+		t.Error("expired entry kept after it was read")
+	}
+	// a new ttl brings a key back
+	c.Set("expired", []byte("3"), time.Hour)
+	if got, ok := c.Get("expired"); !ok || string(got) != "3" {
+		t.Errorf("Get after a new Set = %q, %v", got, ok)
+	}
+}
+
+func TestLRUDeleteAndClear(t *testing.T) {
+	c := NewLRU(0)
+	c.Set("a", []byte("1"), time.Hour)
+	c.Set("b", []byte("2"), time.Hour)
+	if _, ok := c.Get("a"); ok {
+		t.Error("a cache of size 0 kept more than one entry")
+	}
+	c.Delete("b")
+	if _, ok := c.Get("b"); ok {
+		t.Error("deleted entry returned")
+	}
+	c.Set("c", []byte("3"), time.Hour)
+	c.Clear()
+	if _, ok := c.Get("c"); ok || c.order.Len() != 0 {
+		t.Error("entries left after Clear")
+	}
+}
@@ -1,5 +1,62 @@
 package config
 
+import (
+	"os"
+	"strings"
+)
+
+// OIDCProvider is an external openid connect provider users can log in with.
+// RedirectURL is where the provider sends the browser back with the code - it
+// must be registered with the provider
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
 type AppConfig struct {
-	IsProduction bool
+	IsProduction  bool
+	OIDCProviders map[string]*OIDCProvider
+}
+
+// Load builds the app config from the environment
+func Load() AppConfig {
+	return AppConfig{
+		IsProduction:  os.Getenv("APP_ENV") != "development",
+		OIDCProviders: loadOIDCProviders(),
+	}
+}
+
+// loadOIDCProviders reads OIDC_PROVIDERS, a comma separated list of names, and
+// for each name the OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET,
+// _REDIRECT_URL and optional _SCOPES settings. Providers missing an issuer or
+// client id are skipped
+func loadOIDCProviders() map[string]*OIDCProvider {
+	providers := make(map[string]*OIDCProvider)
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &OIDCProvider{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       []string{"openid", "email", "profile"},
+		}
+		if scopes := os.Getenv(prefix + "SCOPES"); scopes != "" {
+			p.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+		}
+		if p.Issuer == "" || p.ClientID == "" {
+			continue
+		}
+		providers[name] = p
+	}
+	return providers
 }
@@ -58,3 +58,22 @@ func GetCollection(
 	coll := client.Database("gobourbon").Collection(collectionName)
 	return coll
 }
+
+// WithTransaction runs fn inside a multi document transaction on the shared
+// client - every read and write in fn must use the session context it is
+// handed so they commit or roll back together. fn may be retried by the
+// driver on transient errors so it should not hold state between attempts
+func WithTransaction(fn func(sc mongo.SessionContext) error) error {
+	session, err := DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.TODO())
+	_, err = session.WithTransaction(
+		context.TODO(),
+		func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		},
+	)
+	return err
+}
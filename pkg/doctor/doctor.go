@@ -0,0 +1,518 @@
+// Package doctor scans the denormalized data that user documents and
+// collections carry for drift from the documents they were copied from and
+// optionally repairs it
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"sort"
+	"time"
+)
+
+// Collections are the collections a scan reads and repairs
+type Collections struct {
+	Users       *mongo.Collection
+	Bourbons    *mongo.Collection
+	Collections *mongo.Collection
+	Wishlists   *mongo.Collection
+	Reviews     *mongo.Collection
+}
+
+// updater is the write side of a collection - every repair updates one
+// document
+type updater interface {
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+}
+
+// issue kinds found by a scan
+const (
+	KindDanglingRef      = "dangling_ref"
+	KindMissingRef       = "missing_ref"
+	KindNameMismatch     = "name_mismatch"
+	KindBourbonsDiffer   = "bourbons_differ"
+	KindOrphanList       = "orphan_list"
+	KindStaleBourbon     = "stale_bourbon"
+	KindDeletedBourbon   = "deleted_bourbon"
+	KindReviewTitle      = "review_title_mismatch"
+	KindOrphanReview     = "orphan_review"
+	KindMissingReviewRef = "missing_review_ref"
+)
+
+type Issue struct {
+	Kind   string             `json:"kind"`
+	Type   string             `json:"type"`
+	UserID primitive.ObjectID `json:"user_id"`
+	DocID  primitive.ObjectID `json:"doc_id"`
+	Detail string             `json:"detail"`
+	Fixed  bool               `json:"fixed"`
+	Error  string             `json:"error,omitempty"`
+}
+
+type Report struct {
+	Fix                bool      `json:"fix"`
+	StartedAt          time.Time `json:"started_at"`
+	FinishedAt         time.Time `json:"finished_at"`
+	UsersScanned       int       `json:"users_scanned"`
+	CollectionsScanned int       `json:"collections_scanned"`
+	WishlistsScanned   int       `json:"wishlists_scanned"`
+	ReviewsScanned     int       `json:"reviews_scanned"`
+	Issues             []*Issue  `json:"issues"`
+}
+
+// listType describes one of the two list document types and where its refs
+// live on the user document
+type listType struct {
+	name       string
+	coll       updater
+	refsField  string
+	idField    string
+	nameField  string
+	listsByID  map[primitive.ObjectID]*models.Collection
+	byOwner    map[primitive.ObjectID][]primitive.ObjectID
+	scannedPtr *int
+}
+
+func (r *Report) add(i *Issue) *Issue {
+	r.Issues = append(r.Issues, i)
+	return i
+}
+
+// apply runs a repair for an issue when the report is fixing and records the outcome
+func (r *Report) apply(i *Issue, fn func() error) {
+	if !r.Fix {
+		return
+	}
+	if err := fn(); err != nil {
+		i.Error = err.Error()
+		return
+	}
+	i.Fixed = true
+}
+
+// Run scans users, collections, wishlists and reviews for drift - when fix is
+// true every fixable issue is repaired as it is found. The list documents and
+// reviews are treated as the source of truth over the refs copied onto users,
+// and the catalog is the source of truth for embedded bourbons
+func Run(ctx context.Context, c Collections, fix bool) (*Report, error) {
+	report := &Report{Fix: fix, StartedAt: time.Now(), Issues: make([]*Issue, 0)}
+
+	catalog, err := loadCatalog(ctx, c.Bourbons)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := loadLists(ctx, c.Collections)
+	if err != nil {
+		return nil, err
+	}
+	wishlists, err := loadLists(ctx, c.Wishlists)
+	if err != nil {
+		return nil, err
+	}
+	lists := []*listType{
+		{name: "collection", coll: c.Collections, listsByID: collections, refsField: "collections", idField: "collection_id", nameField: "collection_name", scannedPtr: &report.CollectionsScanned},
+		{name: "wishlist", coll: c.Wishlists, listsByID: wishlists, refsField: "wishlists", idField: "wishlist_id", nameField: "wishlist_name", scannedPtr: &report.WishlistsScanned},
+	}
+	for _, lt := range lists {
+		*lt.scannedPtr = len(lt.listsByID)
+		lt.byOwner = make(map[primitive.ObjectID][]primitive.ObjectID)
+		for id, list := range lt.listsByID {
+			if list.User != nil {
+				lt.byOwner[list.User.ID] = append(lt.byOwner[list.User.ID], id)
+			}
+		}
+		checkEmbeddedBourbons(ctx, report, c.Users, lt, catalog)
+	}
+	reviews, err := loadReviews(ctx, c.Reviews)
+	if err != nil {
+		return nil, err
+	}
+	report.ReviewsScanned = len(reviews)
+	reviewsByAuthor := make(map[primitive.ObjectID][]primitive.ObjectID)
+	for id, review := range reviews {
+		if review.User != nil {
+			reviewsByAuthor[review.User.ID] = append(reviewsByAuthor[review.User.ID], id)
+		}
+	}
+
+	users := make(map[primitive.ObjectID]bool)
+	cursor, err := c.Users.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var u models.User
+		if err := cursor.Decode(&u); err != nil {
+			return nil, err
+		}
+		report.UsersScanned++
+		users[u.ID] = true
+		checkUserLists(ctx, report, c.Users, &u, lists[0], collectionRefs(&u))
+		checkUserLists(ctx, report, c.Users, &u, lists[1], wishlistRefs(&u))
+		checkUserReviews(ctx, report, c.Users, &u, reviews, reviewsByAuthor[u.ID])
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	for _, lt := range lists {
+		checkOrphanLists(report, lt, users)
+	}
+	checkOrphanReviews(report, reviews, users)
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// ref is the common shape of a UserCollectionRef and a UserWishlistRef
+type ref struct {
+	id       primitive.ObjectID
+	name     string
+	bourbons []primitive.ObjectID
+}
+
+func collectionRefs(u *models.User) []ref {
+	refs := make([]ref, 0, len(u.Collections))
+	for _, c := range u.Collections {
+		refs = append(refs, ref{id: c.CollectionID, name: c.CollectionName, bourbons: bourbonRefIds(c.Bourbons)})
+	}
+	return refs
+}
+
+func wishlistRefs(u *models.User) []ref {
+	refs := make([]ref, 0, len(u.Wishlists))
+	for _, w := range u.Wishlists {
+		refs = append(refs, ref{id: w.WishlistID, name: w.WishlistName, bourbons: bourbonRefIds(w.Bourbons)})
+	}
+	return refs
+}
+
+func bourbonRefIds(b []*models.BourbonsRef) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(b))
+	for _, r := range b {
+		ids = append(ids, r.BourbonID)
+	}
+	return ids
+}
+
+func embeddedBourbonIds(b []*models.CollectionBourbon) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(b))
+	for _, r := range b {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// sameIds compares two id lists as sets - order within a ref is not meaningful
+func sameIds(a, b []primitive.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[primitive.ObjectID]int, len(a))
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+		if seen[id] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func checkUserLists(ctx context.Context, report *Report, users updater, u *models.User, lt *listType, refs []ref) {
+	seen := make(map[primitive.ObjectID]bool, len(refs))
+	for _, rf := range refs {
+		seen[rf.id] = true
+		list, ok := lt.listsByID[rf.id]
+		if !ok || list.User == nil || list.User.ID != u.ID {
+			i := report.add(&Issue{Kind: KindDanglingRef, Type: lt.name, UserID: u.ID, DocID: rf.id, Detail: fmt.Sprintf("user ref %q points at a %s the user does not own", rf.name, lt.name)})
+			report.apply(i, func() error {
+				_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$pull": bson.M{lt.refsField: bson.M{lt.idField: rf.id}}})
+				return err
+			})
+			continue
+		}
+		filter := bson.M{"_id": u.ID, lt.refsField + "." + lt.idField: rf.id}
+		if rf.name != list.Name {
+			i := report.add(&Issue{Kind: KindNameMismatch, Type: lt.name, UserID: u.ID, DocID: rf.id, Detail: fmt.Sprintf("user ref name %q but %s name %q", rf.name, lt.name, list.Name)})
+			report.apply(i, func() error {
+				_, err := users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{lt.refsField + ".$." + lt.nameField: list.Name}})
+				return err
+			})
+		}
+		listIds := embeddedBourbonIds(list.Bourbons)
+		if !sameIds(rf.bourbons, listIds) {
+			i := report.add(&Issue{Kind: KindBourbonsDiffer, Type: lt.name, UserID: u.ID, DocID: rf.id, Detail: fmt.Sprintf("user ref has %d bourbons but %s has %d", len(rf.bourbons), lt.name, len(listIds))})
+			report.apply(i, func() error {
+				bRefs := make([]*models.BourbonsRef, 0, len(listIds))
+				for _, id := range listIds {
+					bRefs = append(bRefs, &models.BourbonsRef{BourbonID: id})
+				}
+				_, err := users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{lt.refsField + ".$.bourbons": bRefs}})
+				return err
+			})
+		}
+	}
+	// lists the user owns that have no ref on the user document
+	for _, id := range lt.byOwner[u.ID] {
+		if seen[id] {
+			continue
+		}
+		list := lt.listsByID[id]
+		i := report.add(&Issue{Kind: KindMissingRef, Type: lt.name, UserID: u.ID, DocID: id, Detail: fmt.Sprintf("%s %q has no user ref", lt.name, list.Name)})
+		report.apply(i, func() error {
+			bRefs := make([]*models.BourbonsRef, 0, len(list.Bourbons))
+			for _, bid := range embeddedBourbonIds(list.Bourbons) {
+				bRefs = append(bRefs, &models.BourbonsRef{BourbonID: bid})
+			}
+			newRef := bson.M{lt.idField: id, lt.nameField: list.Name, "bourbons": bRefs}
+			_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$push": bson.M{lt.refsField: newRef}})
+			return err
+		})
+	}
+}
+
+// checkOrphanLists reports lists whose owner no longer exists - these are not
+// fixed automatically as removing them loses data
+func checkOrphanLists(report *Report, lt *listType, users map[primitive.ObjectID]bool) {
+	for id, list := range lt.listsByID {
+		if list.User != nil && users[list.User.ID] {
+			continue
+		}
+		i := &Issue{Kind: KindOrphanList, Type: lt.name, DocID: id, Detail: fmt.Sprintf("%s %q has no existing owner", lt.name, list.Name)}
+		if list.User != nil {
+			i.UserID = list.User.ID
+		}
+		report.add(i)
+	}
+}
+
+// checkEmbeddedBourbons compares the bourbons embedded in every list with the
+// catalog. A deleted bourbon that is pulled is dropped from the loaded list as
+// well, as the user refs are checked against the loaded lists afterwards
+func checkEmbeddedBourbons(ctx context.Context, report *Report, users updater, lt *listType, catalog map[primitive.ObjectID]*models.Bourbon) {
+	for id, list := range lt.listsByID {
+		var owner primitive.ObjectID
+		if list.User != nil {
+			owner = list.User.ID
+		}
+		kept := make([]*models.CollectionBourbon, 0, len(list.Bourbons))
+		for _, b := range list.Bourbons {
+			current, ok := catalog[b.ID]
+			if !ok {
+				i := report.add(&Issue{Kind: KindDeletedBourbon, Type: lt.name, UserID: owner, DocID: id, Detail: fmt.Sprintf("bourbon %s (%q) is no longer in the catalog", b.ID.Hex(), b.Title)})
+				bId := b.ID
+				report.apply(i, func() error {
+					update := bson.M{
+						"$pull": bson.M{"bourbons": bson.M{"_id": bId}},
+						"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+					}
+					_, err := lt.coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+					if err != nil {
+						return err
+					}
+					uFilter := bson.M{"_id": owner, lt.refsField + "." + lt.idField: id}
+					_, err = users.UpdateOne(ctx, uFilter, bson.M{"$pull": bson.M{lt.refsField + ".$.bourbons": bson.M{"bourbon_id": bId}}})
+					return err
+				})
+				if !i.Fixed {
+					kept = append(kept, b)
+				}
+				continue
+			}
+			kept = append(kept, b)
+			if sameBourbon(&b.Bourbon, current) {
+				continue
+			}
+			i := report.add(&Issue{Kind: KindStaleBourbon, Type: lt.name, UserID: owner, DocID: id, Detail: fmt.Sprintf("embedded copy of bourbon %s (%q) differs from the catalog", b.ID.Hex(), current.Title)})
+			report.apply(i, func() error {
+				return refreshEmbeddedBourbon(ctx, lt.coll, id, current)
+			})
+		}
+		list.Bourbons = kept
+	}
+}
+
+// sameBourbon compares the catalog fields of two bourbons by their encoding
+func sameBourbon(a, b *models.Bourbon) bool {
+	aBytes, aErr := bson.Marshal(a)
+	bBytes, bErr := bson.Marshal(b)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// refreshEmbeddedBourbon overwrites the catalog fields of an embedded bourbon
+// one by one so anything else stored on the entry is kept
+func refreshEmbeddedBourbon(ctx context.Context, coll updater, listId primitive.ObjectID, current *models.Bourbon) error {
+	raw, err := bson.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+	set := bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())}
+	for k, v := range fields {
+		if k == "_id" {
+			continue
+		}
+		set["bourbons.$[b]."+k] = v
+	}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"b._id": current.ID}}})
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": listId}, bson.M{"$set": set}, opts)
+	return err
+}
+
+func checkUserReviews(ctx context.Context, report *Report, users updater, u *models.User, reviews map[primitive.ObjectID]*models.UserReview, authored []primitive.ObjectID) {
+	seen := make(map[primitive.ObjectID]bool, len(u.Reviews))
+	for _, rr := range u.Reviews {
+		seen[rr.ReviewID] = true
+		review, ok := reviews[rr.ReviewID]
+		if !ok || review.User == nil || review.User.ID != u.ID {
+			i := report.add(&Issue{Kind: KindDanglingRef, Type: "review", UserID: u.ID, DocID: rr.ReviewID, Detail: fmt.Sprintf("user ref %q points at a review the user did not write", rr.ReviewTitle)})
+			rId := rr.ReviewID
+			report.apply(i, func() error {
+				_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$pull": bson.M{"reviews": bson.M{"review_id": rId}}})
+				return err
+			})
+			continue
+		}
+		if rr.ReviewTitle != review.ReviewTitle {
+			i := report.add(&Issue{Kind: KindReviewTitle, Type: "review", UserID: u.ID, DocID: rr.ReviewID, Detail: fmt.Sprintf("user ref title %q but review title %q", rr.ReviewTitle, review.ReviewTitle)})
+			rId := rr.ReviewID
+			title := review.ReviewTitle
+			report.apply(i, func() error {
+				filter := bson.M{"_id": u.ID, "reviews.review_id": rId}
+				_, err := users.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"reviews.$.review_title": title}})
+				return err
+			})
+		}
+	}
+	for _, id := range authored {
+		if seen[id] {
+			continue
+		}
+		review := reviews[id]
+		i := report.add(&Issue{Kind: KindMissingReviewRef, Type: "review", UserID: u.ID, DocID: id, Detail: fmt.Sprintf("review %q has no user ref", review.ReviewTitle)})
+		rRef := models.UserReviewRef{ReviewID: id, ReviewTitle: review.ReviewTitle}
+		report.apply(i, func() error {
+			_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$push": bson.M{"reviews": rRef}})
+			return err
+		})
+	}
+}
+
+// checkOrphanReviews reports reviews whose author no longer exists - reviews
+// are kept for the catalog so these are only reported. Reviews anonymized by
+// an account deletion are expected and skipped
+func checkOrphanReviews(report *Report, reviews map[primitive.ObjectID]*models.UserReview, users map[primitive.ObjectID]bool) {
+	for id, review := range reviews {
+		if review.User == nil || review.User.IsTombstone() || users[review.User.ID] {
+			continue
+		}
+		report.add(&Issue{Kind: KindOrphanReview, Type: "review", UserID: review.User.ID, DocID: id, Detail: fmt.Sprintf("review %q has no existing author", review.ReviewTitle)})
+	}
+}
+
+func loadCatalog(ctx context.Context, coll *mongo.Collection) (map[primitive.ObjectID]*models.Bourbon, error) {
+	catalog := make(map[primitive.ObjectID]*models.Bourbon)
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var b models.Bourbon
+		if err := cursor.Decode(&b); err != nil {
+			return nil, err
+		}
+		catalog[b.ID] = &b
+	}
+	return catalog, cursor.Err()
+}
+
+func loadLists(ctx context.Context, coll *mongo.Collection) (map[primitive.ObjectID]*models.Collection, error) {
+	lists := make(map[primitive.ObjectID]*models.Collection)
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var c models.Collection
+		if err := cursor.Decode(&c); err != nil {
+			return nil, err
+		}
+		lists[c.ID] = &c
+	}
+	return lists, cursor.Err()
+}
+
+func loadReviews(ctx context.Context, coll *mongo.Collection) (map[primitive.ObjectID]*models.UserReview, error) {
+	reviews := make(map[primitive.ObjectID]*models.UserReview)
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var r models.UserReview
+		if err := cursor.Decode(&r); err != nil {
+			return nil, err
+		}
+		reviews[r.ID] = &r
+	}
+	return reviews, cursor.Err()
+}
+
+// Print writes a human readable version of the report grouped by issue kind
+func (r *Report) Print(w io.Writer) {
+	mode := "scan only"
+	if r.Fix {
+		mode = "scan and fix"
+	}
+	fmt.Fprintf(w, "doctor report (%s) - %s\n", mode, r.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "scanned %d users, %d collections, %d wishlists, %d reviews in %s\n",
+		r.UsersScanned, r.CollectionsScanned, r.WishlistsScanned, r.ReviewsScanned, r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond))
+	if len(r.Issues) == 0 {
+		fmt.Fprintln(w, "no issues found")
+		return
+	}
+	byKind := make(map[string][]*Issue)
+	kinds := make([]string, 0)
+	fixed := 0
+	for _, i := range r.Issues {
+		if _, ok := byKind[i.Kind]; !ok {
+			kinds = append(kinds, i.Kind)
+		}
+		byKind[i.Kind] = append(byKind[i.Kind], i)
+		if i.Fixed {
+			fixed++
+		}
+	}
+	sort.Strings(kinds)
+	for _, k := range kinds {
+		fmt.Fprintf(w, "\n%s (%d)\n", k, len(byKind[k]))
+		for _, i := range byKind[k] {
+			status := ""
+			if i.Fixed {
+				status = " [fixed]"
+			} else if i.Error != "" {
+				status = " [fix failed: " + i.Error + "]"
+			}
+			fmt.Fprintf(w, "  %s %s user %s: %s%s\n", i.Type, i.DocID.Hex(), i.UserID.Hex(), i.Detail, status)
+		}
+	}
+	fmt.Fprintf(w, "\n%d issues found, %d fixed\n", len(r.Issues), fixed)
+}
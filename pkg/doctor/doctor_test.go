@@ -0,0 +1,68 @@
+package doctor
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"testing"
+)
+
+// recorder stands in for a collection and keeps the updates it was sent
+type recorder struct {
+	updates []interface{}
+}
+
+func (r *recorder) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	r.updates = append(r.updates, update)
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+}
+
+func TestDeletedBourbonLeavesRefsConsistent(t *testing.T) {
+	for _, fix := range []bool{false, true} {
+		kept := &models.CollectionBourbon{Bourbon: models.Bourbon{ID: primitive.NewObjectID(), Title: "Blanton's"}}
+		deleted := &models.CollectionBourbon{Bourbon: models.Bourbon{ID: primitive.NewObjectID(), Title: "Gone"}}
+		owner := &models.UserRef{ID: primitive.NewObjectID(), Username: "owner"}
+		listId := primitive.NewObjectID()
+		lists, users := &recorder{}, &recorder{}
+		lt := &listType{
+			name:      "collection",
+			coll:      lists,
+			refsField: "collections",
+			idField:   "collection_id",
+			nameField: "collection_name",
+			listsByID: map[primitive.ObjectID]*models.Collection{
+				listId: {ID: listId, User: owner, Name: "Shelf", Bourbons: []*models.CollectionBourbon{kept, deleted}},
+			},
+			byOwner: map[primitive.ObjectID][]primitive.ObjectID{owner.ID: {listId}},
+		}
+		catalog := map[primitive.ObjectID]*models.Bourbon{kept.ID: &kept.Bourbon}
+		report := &Report{Fix: fix}
+
+		checkEmbeddedBourbons(context.Background(), report, users, lt, catalog)
+		if len(report.Issues) != 1 || report.Issues[0].Kind != KindDeletedBourbon || report.Issues[0].Fixed != fix {
+			t.Fatalf("fix %v: issues = %+v, want one deleted_bourbon", fix, report.Issues)
+		}
+		// the owner as read once the embedded pass is done - without fix
+		// mode the ref still holds the deleted bourbon
+		refs := []*models.BourbonsRef{{BourbonID: kept.ID}}
+		if !fix {
+			refs = append(refs, &models.BourbonsRef{BourbonID: deleted.ID})
+		}
+		u := &models.User{ID: owner.ID, Collections: []*models.UserCollectionRef{
+			{CollectionID: listId, CollectionName: "Shelf", Bourbons: refs},
+		}}
+		pulls := len(users.updates)
+		checkUserLists(context.Background(), report, users, u, lt, collectionRefs(u))
+		for _, i := range report.Issues[1:] {
+			t.Errorf("fix %v: unexpected %s issue: %s", fix, i.Kind, i.Detail)
+		}
+		if len(users.updates) != pulls {
+			t.Errorf("fix %v: ref check wrote %v", fix, users.updates[pulls:])
+		}
+		if fix && (len(lists.updates) != 1 || pulls != 1) {
+			t.Errorf("fix %v: %d list and %d ref updates, want the bourbon pulled from both", fix, len(lists.updates), pulls)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package expiring is the in-process map behind the memory stores. Every
+// entry carries the time it is forgotten at - expired entries read as missing
+// and are swept out every few writes rather than on a timer
+package expiring
+
+import (
+	"sync"
+	"time"
+)
+
+type entry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// Map is a string keyed map of V that is safe for concurrent use
+type Map[V any] struct {
+	mu         sync.Mutex
+	entries    map[string]entry[V]
+	sweepEvery int
+	writes     int
+}
+
+// New returns a map that sweeps expired entries every sweepEvery writes
+func New[V any](sweepEvery int) *Map[V] {
+	return &Map[V]{entries: make(map[string]entry[V]), sweepEvery: sweepEvery}
+}
+
+// Get returns the value of a key that has not expired at now
+func (m *Map[V]) Get(key string, now time.Time) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get(key, now)
+}
+
+// Update calls fn with the value of a key at now - ok is false when there is
+// none - and stores what it returns until expires. fn runs under the lock so
+// a read and the write that follows it cannot interleave with another Update
+func (m *Map[V]) Update(key string, now time.Time, fn func(v V, ok bool) (V, time.Time)) V {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, expires := fn(m.get(key, now))
+	m.entries[key] = entry[V]{value: v, expires: expires}
+	m.writes++
+	if m.writes >= m.sweepEvery {
+		m.writes = 0
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+	return v
+}
+
+func (m *Map[V]) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// Len counts the entries kept, expired ones that are not swept yet included
+func (m *Map[V]) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+func (m *Map[V]) get(key string, now time.Time) (V, bool) {
+	e, ok := m.entries[key]
+	if !ok || now.After(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
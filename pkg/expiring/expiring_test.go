@@ -0,0 +1,56 @@
+package expiring
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMap(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := New[int](3)
+	incr := func(key string, at time.Time) int {
+		return m.Update(key, at, func(v int, ok bool) (int, time.Time) {
+			return v + 1, at.Add(time.Minute)
+		})
+	}
+	if got := incr("a", now); got != 1 {
+		t.Errorf("first Update = %d, want 1", got)
+	}
+	if got := incr("a", now.Add(30*time.Second)); got != 2 {
+		t.Errorf("Update before expiry = %d, want 2", got)
+	}
+	if v, ok := m.Get("a", now.Add(time.Minute)); !ok || v != 2 {
+		t.Errorf("Get before expiry = %d, %v, want 2, true", v, ok)
+	}
+	if _, ok := m.Get("a", now.Add(2*time.Minute)); ok {
+		t.Error("Get returned an expired entry")
+	}
+	// an expired entry starts over
+	if got := incr("a", now.Add(2*time.Minute)); got != 1 {
+		t.Errorf("Update after expiry = %d, want 1", got)
+	}
+	m.Delete("a")
+	if _, ok := m.Get("a", now); ok {
+		t.Error("Get returned a deleted entry")
+	}
+}
+
+func TestMapSweep(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	m := New[string](3)
+	set := func(key string, at time.Time) {
+		m.Update(key, at, func(string, bool) (string, time.Time) {
+			return key, at.Add(time.Second)
+		})
+	}
+	set("a", now)
+	set("b", now)
+	if m.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", m.Len())
+	}
+	// the third write sweeps a and b, which expired a second after now
+	set("c", now.Add(time.Minute))
+	if m.Len() != 1 {
+		t.Errorf("Len after the sweep = %d, want 1", m.Len())
+	}
+}
@@ -0,0 +1,158 @@
+// Package export writes everything the api keeps about a user into a zip
+// archive - every part as json plus flat csv files for spreadsheets
+package export
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Archive is the data of one user - lists are the ones the user owns
+type Archive struct {
+	User        *models.User
+	Collections []*models.Collection
+	Wishlists   []*models.Collection
+	Reviews     []*models.UserReview
+	CreatedAt   time.Time
+}
+
+// FileName is the suggested download name of the archive
+func (a *Archive) FileName() string {
+	return "hellobourbon-export-" + a.User.Username + "-" + a.CreatedAt.Format("20060102") + ".zip"
+}
+
+// Write streams the archive as a zip to w
+func (a *Archive) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	jsonFiles := []struct {
+		name string
+		v    interface{}
+	}{
+		{"profile.json", a.profile()},
+		{"collections.json", a.Collections},
+		{"wishlists.json", a.Wishlists},
+		{"reviews.json", a.Reviews},
+	}
+	for _, f := range jsonFiles {
+		if err := a.writeJSON(zw, f.name, f.v); err != nil {
+			return err
+		}
+	}
+	if err := a.writeCSV(zw, "collections.csv", listHeader, listRows(a.Collections)); err != nil {
+		return err
+	}
+	if err := a.writeCSV(zw, "wishlists.csv", listHeader, listRows(a.Wishlists)); err != nil {
+		return err
+	}
+	if err := a.writeCSV(zw, "reviews.csv", reviewHeader, reviewRows(a.Reviews)); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// profile is the user document without the password hash or session tokens -
+// the json tags on models.User already leave those out
+func (a *Archive) profile() interface{} {
+	return struct {
+		User       *models.User `json:"user"`
+		ExportedAt time.Time    `json:"exported_at"`
+	}{a.User, a.CreatedAt}
+}
+
+func (a *Archive) create(zw *zip.Writer, name string) (io.Writer, error) {
+	return zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: a.CreatedAt,
+	})
+}
+
+func (a *Archive) writeJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := a.create(zw, name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (a *Archive) writeCSV(zw *zip.Writer, name string, header []string, rows [][]string) error {
+	f, err := a.create(zw, name)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+var listHeader = []string{"list_id", "list_name", "private", "bourbon_id", "title", "distiller", "bottler", "abv", "age", "tags", "notes", "price_paid", "purchased_at", "added_at"}
+
+// listRows writes one row per bourbon so the file opens as a plain bottle list
+func listRows(lists []*models.Collection) [][]string {
+	rows := make([][]string, 0)
+	for _, l := range lists {
+		for _, e := range l.Bourbons {
+			price := ""
+			if e.PricePaid > 0 {
+				price = strconv.FormatFloat(e.PricePaid, 'f', 2, 64)
+			}
+			rows = append(rows, []string{
+				l.ID.Hex(),
+				l.Name,
+				strconv.FormatBool(l.Private),
+				e.ID.Hex(),
+				e.Title,
+				e.Distiller,
+				e.Bottler,
+				e.Abv,
+				e.Age,
+				strings.Join(e.Tags, ";"),
+				e.Notes,
+				price,
+				formatDate(e.PurchasedAt),
+				formatDate(e.AddedAt),
+			})
+		}
+	}
+	return rows
+}
+
+var reviewHeader = []string{"review_id", "bourbon_id", "bourbon_name", "title", "score", "text", "created_at", "updated_at"}
+
+func reviewRows(reviews []*models.UserReview) [][]string {
+	rows := make([][]string, 0, len(reviews))
+	for _, r := range reviews {
+		rows = append(rows, []string{
+			r.ID.Hex(),
+			r.BourbonID.Hex(),
+			r.BourbonName,
+			r.ReviewTitle,
+			r.ReviewScore,
+			r.ReviewText,
+			formatDate(r.CreatedAt),
+			formatDate(r.UpdatedAt),
+		})
+	}
+	return rows
+}
+
+func formatDate(d primitive.DateTime) string {
+	if d == 0 {
+		return ""
+	}
+	return d.Time().UTC().Format(time.RFC3339)
+}
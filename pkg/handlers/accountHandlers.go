@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/mailer"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/validation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var accountTokensCollection = db.GetCollection(db.DB, "account_tokens")
+
+// mail sends the account emails - see mailer.FromEnv for the settings
+var mail = mailer.FromEnv()
+
+// account token settings - links in emails point at the frontend under appURL
+var (
+	resetTokenTTL  = helpers.EnvDuration("RESET_TOKEN_TTL", time.Hour)
+	verifyTokenTTL = helpers.EnvDuration("VERIFY_TOKEN_TTL", 48*time.Hour)
+	appURL         = envOr("APP_URL", "https://hellogobourbon.netlify.app")
+)
+
+var errInvalidAccountToken = errors.New("token is invalid, expired or already used")
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// issueAccountToken stores a new token for the purpose and returns it - any
+// unused token of the same purpose stops working
+func issueAccountToken(uId primitive.ObjectID, purpose, email string, ttl time.Duration) (string, error) {
+	token, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	filter := bson.M{"user_id": uId, "purpose": purpose, "usedAt": bson.M{"$exists": false}}
+	if _, err := accountTokensCollection.DeleteMany(context.TODO(), filter); err != nil {
+		return "", err
+	}
+	var at models.AccountToken
+	at.Build(uId, purpose, helpers.HashToken(token), email, ttl)
+	if _, err := accountTokensCollection.InsertOne(context.TODO(), at); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeAccountToken marks a token as used and returns it - the update only
+// matches an unused and unexpired token so a token works exactly once
+func consumeAccountToken(token, purpose string) (*models.AccountToken, error) {
+	if token == "" {
+		return nil, errInvalidAccountToken
+	}
+	now := primitive.NewDateTimeFromTime(time.Now())
+	filter := bson.M{
+		"hash":      helpers.HashToken(token),
+		"purpose":   purpose,
+		"usedAt":    bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"usedAt": now}}
+	var at models.AccountToken
+	if err := accountTokensCollection.FindOneAndUpdate(context.TODO(), filter, update).Decode(&at); err != nil {
+		return nil, errInvalidAccountToken
+	}
+	return &at, nil
+}
+
+func accountLink(path, token string) string {
+	return appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail mails a verification link for the current email of
+// the user
+func sendVerificationEmail(user *models.User) error {
+	token, err := issueAccountToken(user.ID, models.TokenEmailVerify, user.Email, verifyTokenTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your helloBourbon email",
+		Body: "Hi " + user.Username + ",\n\nConfirm this email address by opening the link below:\n\n" +
+			accountLink("/verify-email", token) + "\n\nThe link expires in " + verifyTokenTTL.String() + ".\n",
+	})
+}
+
+// ForgotPassword mails a password reset link when the email belongs to a user -
+// the response is the same either way so emails cannot be probed
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	var sr responses.StandardResponse
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ForgotPasswordRequest
+	if err := json.Unmarshal(rBody, &req); err != nil || req.Email == "" {
+		er.Respond(w, 400, "error", "email is required")
+		return
+	}
+	var user models.User
+	email, _ := validation.NormalizeEmail(req.Email)
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err := usersCollection.FindOne(context.TODO(), bson.M{"email": email}, opts).Decode(&user)
+	// failures are only logged so the response stays the same
+	if err == nil {
+		if mErr := sendPasswordReset(&user); mErr != nil {
+			log.Printf("password reset for %s failed: %v", user.Email, mErr)
+		}
+	}
+	sr.Respond(w, 200, "success", "if the email belongs to an account a reset link has been sent")
+}
+
+// sendPasswordReset issues a reset token and mails the user a link with it
+func sendPasswordReset(user *models.User) error {
+	token, err := issueAccountToken(user.ID, models.TokenPasswordReset, "", resetTokenTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your helloBourbon password",
+		Body: "Hi " + user.Username + ",\n\nSomeone asked to reset your password. If it was you, open the link below:\n\n" +
+			accountLink("/reset-password", token) + "\n\nThe link expires in " + resetTokenTTL.String() +
+			". If it was not you, you can ignore this email.\n",
+	})
+}
+
+// ResetPassword sets a new password with a reset token - every session of the
+// user is revoked
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ResetPasswordRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	if msg := validation.Passwords.Check(req.Password); msg != "" {
+		er.Respond(w, 400, "error", validation.FieldErrors{"password": msg})
+		return
+	}
+	at, tErr := consumeAccountToken(req.Token, models.TokenPasswordReset)
+	if tErr != nil {
+		er.Respond(w, 400, "error", tErr.Error())
+		return
+	}
+	hashed, hErr := helpers.HashPassword(req.Password)
+	if hErr != nil {
+		er.Respond(w, 500, "error", hErr.Error())
+		return
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"password":  hashed,
+			"tokens":    []*models.UserTokenRef{},
+			"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"$unset": bson.M{"noPassword": ""},
+	}
+	result, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": at.UserID}, update)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "password has been reset")
+}
+
+// RequestEmailVerification mails a new verification link to the auth user
+func RequestEmailVerification(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if user.EmailVerified {
+		er.Respond(w, 400, "error", "email is already verified")
+		return
+	}
+	if sErr := sendVerificationEmail(&user); sErr != nil {
+		er.Respond(w, 500, "error", sErr.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "verification email sent")
+}
+
+// VerifyEmail marks the email of a user as verified with a verify token - the
+// token only counts while the user still has the email it was sent to
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.VerifyEmailRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	at, tErr := consumeAccountToken(req.Token, models.TokenEmailVerify)
+	if tErr != nil {
+		er.Respond(w, 400, "error", tErr.Error())
+		return
+	}
+	filter := bson.M{"_id": at.UserID, "email": at.Email}
+	update := bson.M{"$set": bson.M{
+		"emailVerified": true,
+		"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+	}}
+	result, err := usersCollection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 400, "error", errInvalidAccountToken.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "email verified")
+}
+
+// ChangePassword sets a new password for the auth user once the current one
+// is given - every other session is revoked
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ChangePasswordRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	if req.CurrentPassword == "" {
+		er.Respond(w, 400, "error", validation.FieldErrors{"current_password": "is required"})
+		return
+	}
+	if msg := validation.Passwords.Check(req.NewPassword); msg != "" {
+		er.Respond(w, 400, "error", validation.FieldErrors{"new_password": msg})
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if !verifyPasswordHash(req.CurrentPassword, user.Password) {
+		er.Respond(w, 401, "error", "unauthorized")
+		return
+	}
+	hashed, hErr := helpers.HashPassword(req.NewPassword)
+	if hErr != nil {
+		er.Respond(w, 500, "error", hErr.Error())
+		return
+	}
+	update := bson.M{
+		"$set":  bson.M{"password": hashed, "updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+		"$pull": bson.M{"tokens": bson.M{"sessionId": bson.M{"$ne": ctx.SessionID}}},
+	}
+	if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "password changed")
+}
+
+// reauthWindow is how recent a provider login has to be to stand in for the
+// password of a user that has none
+var reauthWindow = helpers.EnvDuration("REAUTH_WINDOW", 5*time.Minute)
+
+// respondReauthenticate checks the auth user is present before a sensitive
+// change and responds with a 401 when they are not. Users with a password
+// give it. Users created through a provider do not know theirs, so a session
+// from a provider login within reauthWindow or a two factor code is taken
+// instead
+func respondReauthenticate(w http.ResponseWriter, user *models.User, sessionId, password, code string) bool {
+	var er responses.ErrorResponse
+	if user.HasPassword() {
+		if !verifyPasswordHash(password, user.Password) {
+			er.Respond(w, 401, "error", "unauthorized")
+			return false
+		}
+		return true
+	}
+	if s := user.Session(sessionId); s != nil && time.Since(s.CreatedAt.Time()) < reauthWindow {
+		return true
+	}
+	if user.TwoFactorEnabled() && code != "" {
+		ok, err := useTwoFactorCode(user, code)
+		if err != nil {
+			er.Respond(w, 500, "error", err.Error())
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	er.Respond(w, 401, "error", "log in through your provider again or give a two factor code")
+	return false
+}
+
+// ChangeEmail moves the auth user to a new email once they reauthenticate -
+// the new email is unverified until the mailed link is used
+func ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ChangeEmailRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	email, ok := validation.NormalizeEmail(req.Email)
+	if !ok {
+		er.Respond(w, 400, "error", validation.FieldErrors{"email": "must be a valid email address"})
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if user.HasPassword() && req.Password == "" {
+		er.Respond(w, 400, "error", validation.FieldErrors{"password": "is required"})
+		return
+	}
+	if !respondReauthenticate(w, &user, ctx.SessionID, req.Password, req.Code) {
+		return
+	}
+	if email == user.Email {
+		er.Respond(w, 400, "error", validation.FieldErrors{"email": "is unchanged"})
+		return
+	}
+	taken, tErr := EmailTaken(context.TODO(), email, user.ID)
+	if tErr != nil {
+		er.Respond(w, 500, "error", tErr.Error())
+		return
+	}
+	if taken {
+		er.Respond(w, 400, "error", validation.FieldErrors{"email": "is already registered"})
+		return
+	}
+	update := bson.M{"$set": bson.M{
+		"email":         email,
+		"emailVerified": false,
+		"updatedAt":     primitive.NewDateTimeFromTime(time.Now()),
+	}}
+	if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+		if fErrs := duplicateUserFields(err); fErrs != nil {
+			er.Respond(w, 400, "error", fErrs)
+			return
+		}
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	user.Email = email
+	user.EmailVerified = false
+	if mErr := sendVerificationEmail(&user); mErr != nil {
+		log.Printf("verification mail to %s failed: %v", user.Email, mErr)
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", &user)
+}
+
+// ChangeUsername renames the auth user. The username is copied into the user
+// ref of every list, membership and review so all of them are updated in one
+// transaction
+func ChangeUsername(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ChangeUsernameRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	username := strings.TrimSpace(req.Username)
+	if msg := validation.CheckUsername(username); msg != "" {
+		er.Respond(w, 400, "error", validation.FieldErrors{"username": msg})
+		return
+	}
+	if username == ctx.Username {
+		er.Respond(w, 400, "error", validation.FieldErrors{"username": "is unchanged"})
+		return
+	}
+	var definedError responses.ErrorResponse
+	var user models.User
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		// the users own name is excluded so the case of it can be changed
+		taken, err := UsernameTaken(sc, username, ctx.UserId)
+		if err != nil {
+			return err
+		}
+		if taken {
+			definedError.Build(400, "error", validation.FieldErrors{"username": "is already taken"})
+			return errTxAborted
+		}
+		updateTime := primitive.NewDateTimeFromTime(time.Now())
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		userUpdate := bson.M{"$set": bson.M{"username": username, "updatedAt": updateTime}}
+		if err := usersCollection.FindOneAndUpdate(sc, bson.M{"_id": ctx.UserId}, userUpdate, opts).Decode(&user); err != nil {
+			if err == mongo.ErrNoDocuments {
+				definedError.Build(404, "error", "user not found")
+				return errTxAborted
+			}
+			if fErrs := duplicateUserFields(err); fErrs != nil {
+				definedError.Build(400, "error", fErrs)
+				return errTxAborted
+			}
+			return err
+		}
+		owned := bson.M{"user.id": ctx.UserId}
+		// the copies of the name are writes to those documents as well
+		refUpdate := bson.M{"$set": bson.M{"user.username": username, "updatedAt": updateTime}}
+		if _, err := reviewsCollection.UpdateMany(sc, owned, refUpdate); err != nil {
+			return err
+		}
+		memberOpts := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"m.user.id": ctx.UserId}},
+		})
+		memberUpdate := bson.M{"$set": bson.M{"members.$[m].user.username": username, "updatedAt": updateTime}}
+		for _, coll := range []*mongo.Collection{collectionsCollection, wishlistsCollection} {
+			if _, err := coll.UpdateMany(sc, owned, refUpdate); err != nil {
+				return err
+			}
+			if _, err := coll.UpdateMany(sc, bson.M{"members.user.id": ctx.UserId}, memberUpdate, memberOpts); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if definedError.Status != 0 {
+		definedError.Respond(w, definedError.Status, definedError.Message, definedError.Data["data"])
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", &user)
+}
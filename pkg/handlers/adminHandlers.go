@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/cache"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/doctor"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/usage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+// RunDoctor scans the denormalized user refs and embedded bourbons for drift -
+// a GET only reports, a POST also repairs what it can
+func RunDoctor(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	var sr responses.StandardResponse
+	fix := r.Method == http.MethodPost
+	report, err := doctor.Run(context.TODO(), doctor.Collections{
+		Users:       usersCollection,
+		Bourbons:    bourbonsCollection,
+		Collections: collectionsCollection,
+		Wishlists:   wishlistsCollection,
+		Reviews:     reviewsCollection,
+	}, fix)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	sr.Respond(w, 200, "success", report)
+}
+
+// ClearCaches drops the cached catalog reads and api key lookups of this
+// instance - for catalog edits made straight in the database
+func ClearCaches(w http.ResponseWriter, r *http.Request) {
+	var sr responses.StandardResponse
+	cache.Catalog.Clear()
+	cache.Keys.Clear()
+	sr.Respond(w, 200, "success", "caches cleared")
+}
+
+// GetKeyUsage sums the recorded requests per api key, route and status. The
+// range defaults to the last 7 days - from and to take RFC3339 times, key
+// limits it to one key and hourly=true keeps the hours apart
+func GetKeyUsage(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	var sr responses.StandardResponse
+	q := r.URL.Query()
+	query := usage.Query{To: time.Now(), Hourly: q.Get("hourly") == "true"}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			er.Respond(w, 400, "error", "to must be an RFC3339 time")
+			return
+		}
+		query.To = t
+	}
+	query.From = query.To.Add(-7 * 24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			er.Respond(w, 400, "error", "from must be an RFC3339 time")
+			return
+		}
+		query.From = t
+	}
+	if v := q.Get("key"); v != "" {
+		id, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			er.Respond(w, 400, "error", "invalid key id")
+			return
+		}
+		query.KeyID = id
+	}
+	report, err := usage.Run(context.TODO(), query)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	sr.Respond(w, 200, "success", report)
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/apikey"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/cache"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var apiKeysCollection = db.GetCollection(db.DB, "keys")
+
+// checkScopes returns what is wrong with the scopes for a key - a key must be
+// granted at least one
+func checkScopes(scopes []string) string {
+	if len(scopes) == 0 {
+		return "scopes must have at least one of " + strings.Join(apikey.Scopes, ", ")
+	}
+	for _, s := range scopes {
+		if !apikey.ValidScope(s) {
+			return "unknown scope " + s
+		}
+	}
+	return ""
+}
+
+// legacyKeyScopes are granted to keys stored before scopes existed - every
+// scope but admin unless LEGACY_KEY_SCOPES lists others
+var legacyKeyScopes = envScopes("LEGACY_KEY_SCOPES", []string{
+	apikey.ScopeCatalogRead,
+	apikey.ScopeReviewsRead,
+	apikey.ScopeReviewsWrite,
+	apikey.ScopeUsersRead,
+	apikey.ScopeUsersWrite,
+	apikey.ScopeCollectionsRead,
+	apikey.ScopeCollectionsWrite,
+})
+
+func envScopes(name string, def []string) []string {
+	v := strings.Fields(strings.ReplaceAll(os.Getenv(name), ",", " "))
+	if len(v) == 0 {
+		return def
+	}
+	return v
+}
+
+// MigrateKeyScopes grants legacyKeyScopes to keys that have no scopes stored,
+// which are refused by every route until they do, and returns how many keys
+// it changed
+func MigrateKeyScopes(ctx context.Context) (int64, error) {
+	if msg := checkScopes(legacyKeyScopes); msg != "" {
+		return 0, errors.New("LEGACY_KEY_SCOPES: " + msg)
+	}
+	update := bson.M{"$set": bson.M{"scopes": legacyKeyScopes}}
+	filter := bson.M{"scopes": bson.M{"$in": bson.A{nil, bson.A{}}}}
+	result, err := apiKeysCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	if result.ModifiedCount > 0 {
+		cache.Keys.Clear()
+	}
+	return result.ModifiedCount, nil
+}
+
+// CreateAPIKey creates a key for an app and responds with its secret - only
+// the hash is stored so the secret cannot be shown again
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.CreateAPIKeyRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	req.AppName = strings.TrimSpace(req.AppName)
+	if req.AppName == "" {
+		er.Respond(w, 400, "error", "app_name is required")
+		return
+	}
+	if msg := checkScopes(req.Scopes); msg != "" {
+		er.Respond(w, 400, "error", msg)
+		return
+	}
+	secret, prefix, hash, gErr := apikey.Generate()
+	if gErr != nil {
+		er.Respond(w, 500, "error", gErr.Error())
+		return
+	}
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		AppName:   req.AppName,
+		Active:    true,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		CreatedBy: ctx.UserId,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+		Limits:    req.Limits,
+		Scopes:    req.Scopes,
+	}
+	if _, err := apiKeysCollection.InsertOne(context.TODO(), key); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 201, "success", responses.APIKeyResponse{Key: &key, Secret: secret})
+}
+
+// GetAPIKeys lists every key, newest first - secrets are never included
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	opts := options.Find().SetSort(bson.M{"createdAt": -1})
+	cursor, err := apiKeysCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	keys := make([]*models.APIKey, 0)
+	if err := cursor.All(context.TODO(), &keys); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.APIKeysResponse{Keys: keys})
+}
+
+// RotateAPIKey gives an active key a new secret - the old secret stops working
+// straight away, and a legacy key stops being accepted by its _id
+func RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	id, iErr := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if iErr != nil {
+		er.Respond(w, 400, "error", "invalid key id")
+		return
+	}
+	secret, prefix, hash, gErr := apikey.Generate()
+	if gErr != nil {
+		er.Respond(w, 500, "error", gErr.Error())
+		return
+	}
+	update := bson.M{"$set": bson.M{
+		"prefix":    prefix,
+		"keyHash":   hash,
+		"rotatedAt": primitive.NewDateTimeFromTime(time.Now()),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var key models.APIKey
+	filter := bson.M{"_id": id, "active": true}
+	if err := apiKeysCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&key); err != nil {
+		er.Respond(w, 404, "error", "active key not found")
+		return
+	}
+	// lookups are cached by the hash of the secret so one key cannot be
+	// picked out - every key write clears them all
+	cache.Keys.Clear()
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.APIKeyResponse{Key: &key, Secret: secret})
+}
+
+// UpdateAPIKeyLimits replaces the rate limits of a key - an empty body puts
+// the key back on the defaults
+func UpdateAPIKeyLimits(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	id, iErr := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if iErr != nil {
+		er.Respond(w, 400, "error", "invalid key id")
+		return
+	}
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var limits models.RateLimits
+	if err := json.Unmarshal(rBody, &limits); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	update := bson.M{"$set": bson.M{"limits": limits}}
+	if limits == (models.RateLimits{}) {
+		update = bson.M{"$unset": bson.M{"limits": ""}}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var key models.APIKey
+	filter := bson.M{"_id": id, "active": true}
+	if err := apiKeysCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&key); err != nil {
+		er.Respond(w, 404, "error", "active key not found")
+		return
+	}
+	cache.Keys.Clear()
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.APIKeyResponse{Key: &key})
+}
+
+// UpdateAPIKeyScopes replaces the scopes of a key
+func UpdateAPIKeyScopes(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	id, iErr := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if iErr != nil {
+		er.Respond(w, 400, "error", "invalid key id")
+		return
+	}
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.UpdateAPIKeyScopesRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	if msg := checkScopes(req.Scopes); msg != "" {
+		er.Respond(w, 400, "error", msg)
+		return
+	}
+	update := bson.M{"$set": bson.M{"scopes": req.Scopes}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var key models.APIKey
+	filter := bson.M{"_id": id, "active": true}
+	if err := apiKeysCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&key); err != nil {
+		er.Respond(w, 404, "error", "active key not found")
+		return
+	}
+	cache.Keys.Clear()
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.APIKeyResponse{Key: &key})
+}
+
+// RevokeAPIKey deactivates a key - it is kept so its history stays readable
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	id, iErr := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if iErr != nil {
+		er.Respond(w, 400, "error", "invalid key id")
+		return
+	}
+	update := bson.M{"$set": bson.M{
+		"active":    false,
+		"revokedAt": primitive.NewDateTimeFromTime(time.Now()),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var key models.APIKey
+	filter := bson.M{"_id": id, "active": true}
+	if err := apiKeysCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&key); err != nil {
+		er.Respond(w, 404, "error", "active key not found")
+		return
+	}
+	cache.Keys.Clear()
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.APIKeyResponse{Key: &key})
+}
@@ -3,16 +3,22 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/cache"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/keyset"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 )
 
 // declare and set collections to collection vars
@@ -21,16 +27,78 @@ var bourbonsCollection = db.GetCollection(
 	"bourbons",
 )
 
-// GetBourbons gets paginated bourbons
+// catalogTTL is how long bourbon reads are cached - the catalog is cleared
+// on writes but edits made straight in the database wait out the ttl
+var catalogTTL = helpers.EnvDuration("CACHE_CATALOG_TTL", 10*time.Minute)
+
+// bourbonsPage is a cached page of GetBourbons - next and prev pages are
+// only set on reads by page number
+type bourbonsPage struct {
+	Bourbons   []*models.Bourbon `bson:"bourbons"`
+	Total      int               `bson:"total"`
+	NextPage   int               `bson:"nextPage,omitempty"`
+	PrevPage   int               `bson:"prevPage,omitempty"`
+	NextCursor string            `bson:"nextCursor,omitempty"`
+	PrevCursor string            `bson:"prevCursor,omitempty"`
+}
+
+// respondBourbonsPage writes a page of bourbons - an empty page is a 404.
+// Reads by page number link to the neighbouring page numbers, reads by
+// cursor to the neighbouring cursors
+func respondBourbonsPage(w http.ResponseWriter, r *http.Request, p *bourbonsPage) {
+	if len(p.Bourbons) == 0 {
+		var er responses.ErrorResponse
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	res := responses.BourbonsResponse{
+		Bourbons:     p.Bourbons,
+		TotalRecords: p.Total,
+		NextCursor:   p.NextCursor,
+		PrevCursor:   p.PrevCursor,
+	}
+	if r.URL.Query().Get("cursor") == "" {
+		if p.NextPage != 0 {
+			res.Next = pageLink(r, p.NextPage)
+		}
+		if p.PrevPage != 0 {
+			res.Prev = pageLink(r, p.PrevPage)
+		}
+	} else {
+		if p.NextCursor != "" {
+			res.Next = bourbonsLink(r, "cursor", p.NextCursor)
+		}
+		if p.PrevCursor != "" {
+			res.Prev = bourbonsLink(r, "cursor", p.PrevCursor)
+		}
+	}
+	var sr responses.StandardResponse
+	sr.RespondConditional(w, r, time.Time{}, "success", res)
+}
+
+// limits on the page size clients can ask GetBourbons for
+const defaultBourbonsLimit = 20
+
+var maxBourbonsLimit = helpers.EnvInt("BOURBONS_MAX_LIMIT", 100)
+
+// GetBourbons gets paginated bourbons - by page number, or by the cursor of
+// a previous response which stays put when bourbons are added or removed
 func GetBourbons(w http.ResponseWriter, r *http.Request) {
 	var er responses.ErrorResponse
-	var sr responses.StandardResponse
 	sortQuery := "title"
 	searchQuery := " "
 	sortDirection := 1
-	limit := 20
+	limit := defaultBourbonsLimit
 	page := 1
 	q := r.URL.Query()
+	if q.Get("limit") != "" {
+		l, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || l < 1 || l > maxBourbonsLimit {
+			er.Respond(w, 400, "error", fmt.Sprintf("limit must be between 1 and %d", maxBourbonsLimit))
+			return
+		}
+		limit = l
+	}
 	if q.Get("page") != "" && q.Get("page") != "1" {
 		p, err := strconv.Atoi(q.Get("page"))
 		if err != nil {
@@ -72,9 +140,30 @@ func GetBourbons(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var after *keyset.Cursor
+	if q.Get("cursor") != "" {
+		c, err := keyset.Decode(q.Get("cursor"))
+		if err != nil {
+			er.Respond(w, 400, "error", err.Error())
+			return
+		}
+		if c.Sort != sortQuery || c.Dir != sortDirection {
+			er.Respond(w, 400, "error", "cursor is for another sort")
+			return
+		}
+		after = c
+		skip = 0
+	}
+
 	if q.Get("search") != " " {
 		searchQuery = q.Get("search")
 	}
+	cacheKey := fmt.Sprintf("bourbons:%s:%d:%d:%d:%s:%s", sortQuery, sortDirection, page, limit, q.Get("cursor"), searchQuery)
+	var cached bourbonsPage
+	if cache.GetBSON(cache.Catalog, cacheKey, &cached) {
+		respondBourbonsPage(w, r, &cached)
+		return
+	}
 	//opts := options.Find().SetSort(bson.D{{sortQuery, sortDirection}}).SetSkip(int64(skip)).SetLimit(int64(limit))
 	pr := primitive.Regex{searchQuery, "i"}
 	// working filter lol
@@ -94,9 +183,17 @@ func GetBourbons(w http.ResponseWriter, r *http.Request) {
 		bson.D{{"distiller", pr}},
 	}
 	matchStage := bson.D{{"$match", bson.D{{"$or", orStage}}}}
-	sortStage := bson.D{{"$sort", bson.D{{sortQuery, sortDirection}}}}
+	readDirection := sortDirection
+	if after != nil {
+		matchStage = bson.D{{Key: "$match", Value: bson.D{{Key: "$and", Value: bson.A{bson.D{{Key: "$or", Value: orStage}}, after.Filter()}}}}}
+		readDirection = after.ReadDir()
+	}
+	// _id breaks ties so the order is the same on every read, which cursors
+	// rely on
+	sortStage := bson.D{{Key: "$sort", Value: bson.D{{Key: sortQuery, Value: readDirection}, {Key: "_id", Value: readDirection}}}}
 	skipStage := bson.D{{"$skip", skip}}
-	limitStage := bson.D{{"$limit", limit}}
+	// one more than the page tells whether there is a next one
+	limitStage := bson.D{{Key: "$limit", Value: limit + 1}}
 	count, ctErr := bourbonsCollection.CountDocuments(
 		context.TODO(),
 		filter,
@@ -106,7 +203,7 @@ func GetBourbons(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var bourbons []*models.Bourbon
+	var raws []bson.Raw
 	cursor, fetchErr := bourbonsCollection.Aggregate(
 		context.TODO(),
 		mongo.Pipeline{matchStage, sortStage, skipStage, limitStage},
@@ -117,33 +214,60 @@ func GetBourbons(w http.ResponseWriter, r *http.Request) {
 	}
 	defer cursor.Close(context.TODO())
 	for cursor.Next(context.TODO()) {
-		var bourbon *models.Bourbon
-		err := cursor.Decode(&bourbon)
-		if err != nil {
-			er.Respond(w, 500, "error", err.Error())
-			return
-		}
-		bourbons = append(
-			bourbons,
-			bourbon,
-		)
+		raws = append(raws, append(bson.Raw(nil), cursor.Current...))
 	}
 
 	if cursErr := cursor.Err(); cursErr != nil {
 		er.Respond(w, 500, "error", cursErr.Error())
 		return
 	}
-	if len(bourbons) > 0 {
-		br := responses.BourbonsResponse{
-			Bourbons:     bourbons,
-			TotalRecords: int(count),
+	more := len(raws) > limit
+	if more {
+		raws = raws[:limit]
+	}
+	// a read before a cursor walks the sort backwards
+	if after != nil && after.Before {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
 		}
-		sr.Respond(w, 200, "success", br)
-	} else {
-		nfError := errors.New("not found")
-		er.Respond(w, 404, "error", nfError.Error())
 	}
-
+	bourbons := make([]*models.Bourbon, 0, len(raws))
+	for _, raw := range raws {
+		var bourbon *models.Bourbon
+		if err := bson.Unmarshal(raw, &bourbon); err != nil {
+			er.Respond(w, 500, "error", err.Error())
+			return
+		}
+		bourbons = append(bourbons, bourbon)
+	}
+	result := &bourbonsPage{Bourbons: bourbons, Total: int(count)}
+	if len(raws) > 0 {
+		hasNext, hasPrev := more, page > 1
+		if after != nil {
+			// the side the cursor came from always has bourbons
+			hasNext, hasPrev = more, true
+			if after.Before {
+				hasNext, hasPrev = true, more
+			}
+		} else {
+			if hasNext {
+				result.NextPage = page + 1
+			}
+			if hasPrev {
+				result.PrevPage = page - 1
+			}
+		}
+		if hasNext {
+			result.NextCursor = keyset.At(raws[len(raws)-1], sortQuery, sortDirection, false).Encode()
+		}
+		if hasPrev {
+			result.PrevCursor = keyset.At(raws[0], sortQuery, sortDirection, true).Encode()
+		}
+	}
+	if err := cache.SetBSON(cache.Catalog, cacheKey, result, catalogTTL); err != nil {
+		log.Printf("bourbons page not cached: %v", err)
+	}
+	respondBourbonsPage(w, r, result)
 }
 
 // GetRandomBourbon gets a random bourbon from the db using a aggregation pipe $sample
@@ -196,8 +320,13 @@ func GetBourbonById(w http.ResponseWriter, r *http.Request) {
 		er.Respond(w, 500, "error", err.Error())
 		return
 	}
-	filter := bson.M{"_id": objectId}
+	cacheKey := "bourbon:" + objectId.Hex()
 	var bourbon models.Bourbon
+	if cache.GetBSON(cache.Catalog, cacheKey, &bourbon) {
+		sr.RespondConditional(w, r, time.Time{}, "success", responses.SingleBourbonResponse{Bourbon: &bourbon})
+		return
+	}
+	filter := bson.M{"_id": objectId}
 	err = bourbonsCollection.FindOne(
 		context.TODO(),
 		filter,
@@ -207,10 +336,13 @@ func GetBourbonById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if bourbon.Title != "" {
+		if err := cache.SetBSON(cache.Catalog, cacheKey, &bourbon, catalogTTL); err != nil {
+			log.Printf("bourbon not cached: %v", err)
+		}
 		br := responses.SingleBourbonResponse{
 			Bourbon: &bourbon,
 		}
-		sr.Respond(w, 200, "success", br)
+		sr.RespondConditional(w, r, time.Time{}, "success", br)
 	} else {
 		err = errors.New("not found")
 		er.Respond(w, 404, "error", err.Error())
@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// bourbonsLink is the request url with its page or cursor swapped, so links
+// keep the sort, search and limit the client asked for
+func bourbonsLink(r *http.Request, param, value string) string {
+	q := r.URL.Query()
+	q.Del("page")
+	q.Del("cursor")
+	q.Set(param, value)
+	return r.URL.Path + "?" + q.Encode()
+}
+
+func pageLink(r *http.Request, page int) string {
+	return bourbonsLink(r, "page", strconv.Itoa(page))
+}
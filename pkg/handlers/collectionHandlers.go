@@ -12,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 var collectionsCollection = db.GetCollection(
@@ -21,7 +22,7 @@ var collectionsCollection = db.GetCollection(
 var wishlistsCollection = db.GetCollection(db.DB, "wishlists")
 
 // GetCollectionTypeById returns a collection/wishlist - if the collection is
-// private then the user making the request must be the owner of
+// private then the user making the request must be the owner or a member of
 // the collection - collection to be used is dependent on cType from router params
 func GetCollectionTypeById(w http.ResponseWriter, r *http.Request) {
 	// params id contains collection id
@@ -44,26 +45,24 @@ func GetCollectionTypeById(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	uId := ctx.UserId
 	var cm models.Collection
-	var cr responses.CollectionResponse
-	var wr responses.WishlistResponse
-	var sr responses.StandardResponse
 	filter := bson.M{"_id": id}
 	err := collectionToUse.FindOne(context.TODO(), filter).Decode(&cm)
 	if err != nil {
 		er.Respond(w, 400, "error", err.Error())
 		return
 	}
-	if cm.Private && cm.User.ID != uId {
+	if cm.Private && cm.RoleFor(uId) == "" {
 		er.Respond(w, 401, "error", "unauthorized")
 		return
 	}
-	if cType == "collection" {
-		cr.Collection = &cm
-		sr.Respond(w, 200, "success", cr)
-	} else {
-		wr.Wishlist = &cm
-		sr.Respond(w, 200, "success", wr)
+	// share links and members are only for owners to see
+	cm.ViewFor(uId)
+	// optional tag filter and sort on the bourbons in the list
+	if qErr := applyEntryQuery(r.URL.Query(), &cm); qErr != nil {
+		er.Respond(w, 400, "error", qErr.Error())
+		return
 	}
+	readCollectionType(w, r, cType, &cm)
 }
 
 func GetCollectionsType(w http.ResponseWriter, r *http.Request) {
@@ -88,7 +87,8 @@ func GetCollectionsType(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	userId := ctx.UserId
-	filter := bson.M{"user.id": userId}
+	// owned collections as well as the ones shared with the user as a member
+	filter := memberAccessFilter(userId)
 	cursor, err := collectionToUse.Find(context.TODO(), filter)
 	if err != nil {
 		er.Respond(w, 400, "error", err.Error())
@@ -106,19 +106,22 @@ func GetCollectionsType(w http.ResponseWriter, r *http.Request) {
 			er.Respond(w, 500, "error", err.Error())
 			return
 		}
+		collection.ViewFor(userId)
 		collections = append(collections, collection)
 	}
 	if cursErr := cursor.Err(); cursErr != nil {
 		er.Respond(w, 500, "error", cursErr.Error())
 		return
 	}
+	// the list is only checked by its ETag - the latest updatedAt in it does
+	// not move when a collection is deleted
 	if len(collections) > 0 {
 		if cType == "collections" {
 			cr.Collections = collections
-			sr.Respond(w, 200, "success", cr)
+			sr.RespondConditional(w, r, time.Time{}, "success", cr)
 		} else {
 			wr.Wishlists = collections
-			sr.Respond(w, 200, "success", wr)
+			sr.RespondConditional(w, r, time.Time{}, "success", wr)
 		}
 	} else {
 		str := emptyMap[cType]
@@ -144,7 +147,7 @@ func CreateCollection(w http.ResponseWriter, r *http.Request) {
 	rBody, _ := ioutil.ReadAll(r.Body)
 	controlStruct, err := CreateController(rBody, id, username, cType)
 	if err.Status != 0 {
-		err.Respond(w, err.Status, err.Message, err.Data)
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
 		return
 	}
 	var cm models.Collection
@@ -179,10 +182,15 @@ func UpdateCollection(w http.ResponseWriter, r *http.Request) {
 	// user id is in the context from auth middleware
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	userId := ctx.UserId
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, userId, models.RoleOwner)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
 	rBody, _ := ioutil.ReadAll(r.Body)
-	controlStuct, err := UpdateController(rBody, collectionId, userId, cType)
+	controlStuct, err := UpdateController(rBody, collectionId, userId, cType, ifUpdatedAt)
 	if err.Status != 0 {
-		err.Respond(w, err.Status, err.Message, err.Data)
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
 		return
 	}
 	var cm models.Collection
@@ -223,7 +231,7 @@ func DeleteCollection(w http.ResponseWriter, r *http.Request) {
 	userId := ctx.UserId
 	err := DeleteController(collectionId, userId, cType)
 	if err.Status != 0 {
-		err.Respond(w, err.Status, err.Message, err.Data)
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
 		return
 	}
 	var sr responses.StandardResponse
@@ -252,31 +260,164 @@ func UpdateBourbonsInCollection(w http.ResponseWriter, r *http.Request) {
 	}
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	userId := ctx.UserId
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, userId, models.RoleOwner, models.RoleEditor)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
 	// ExistsAndUpdateController order of operations:
 	// does the bourbon exist -> does the collection exist and belong to the user
 	// does the bourbon already exist in the collection -> if yes/yes/no -> success
+	controlStruct, err := ExistsAndUpdateController(collectionId, bourbonId, userId, action, cType, ifUpdatedAt)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
+}
 
-	controlStruct, err := ExistsAndUpdateController(collectionId, bourbonId, userId, action, cType)
+// BulkUpdateBourbonsInCollection applies many add/delete operations to one
+// collection or wishlist - every operation gets a result in the response.
+// With atomic=true in the query any failed operation rejects the whole batch
+func BulkUpdateBourbonsInCollection(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	collectionId, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.BulkBourbonRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	atomic := r.URL.Query().Get("atomic") == "true"
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, ctx.UserId, models.RoleOwner, models.RoleEditor)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
+	br, err := BulkBourbonsController(req.Operations, collectionId, ctx.UserId, cType, atomic, ifUpdatedAt)
 	if err.Status != 0 {
-		err.Respond(w, err.Status, err.Message, err.Data)
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
 		return
 	}
-	var cm models.Collection
-	var uCollRef models.UserCollectionRef
-	var uWishRef models.UserWishlistRef
-	var cr responses.CollectionResponse
-	var wr responses.WishlistResponse
 	var sr responses.StandardResponse
-	json.Unmarshal(controlStruct.Element, &cm)
-	if cType == "collection" {
-		json.Unmarshal(controlStruct.UserRef, uCollRef)
-		cr.Collection = &cm
-		cr.UserCollection = &uCollRef
-		sr.Respond(w, 200, "success", cr)
-	} else {
-		json.Unmarshal(controlStruct.UserRef, uWishRef)
-		wr.Wishlist = &cm
-		wr.UserWishlist = &uWishRef
-		sr.Respond(w, 200, "success", wr)
+	sr.Respond(w, 200, "success", br)
+}
+
+// AcquireBourbon moves a bourbon from a wishlist into a collection in one
+// request - the common case of buying a bottle that was on a wishlist
+func AcquireBourbon(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var er responses.ErrorResponse
+	wishlistId, wErr := primitive.ObjectIDFromHex(params["wishlistId"])
+	bourbonId, bErr := primitive.ObjectIDFromHex(params["bourbonId"])
+	collectionId, cErr := primitive.ObjectIDFromHex(params["collectionId"])
+	if wErr != nil || bErr != nil || cErr != nil {
+		er.Respond(w, 400, "error", "bad request")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	ar, err := AcquireController(wishlistId, collectionId, bourbonId, ctx.UserId)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", ar)
+}
+
+// CloneCollection duplicates any collection or wishlist the auth user can read
+// into a new list they own
+func CloneCollection(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	collectionId, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	controlStruct, err := CloneController(rBody, collectionId, ctx.UserId, ctx.Username, cType)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
+}
+
+// MergeCollections merges the source list into the target list and deletes the
+// source unless keepSource=true is passed in the query
+func MergeCollections(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	targetId, tErr := primitive.ObjectIDFromHex(params["id"])
+	sourceId, sErr := primitive.ObjectIDFromHex(params["sourceId"])
+	if tErr != nil || sErr != nil {
+		er.Respond(w, 400, "error", "bad request")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	keepSource := r.URL.Query().Get("keepSource") == "true"
+	// an If-Match is checked against the target
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, targetId, ctx.UserId, models.RoleOwner)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
+	controlStruct, err := MergeController(targetId, sourceId, ctx.UserId, cType, keepSource, ifUpdatedAt)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
+}
+
+// SplitCollection moves the selected bourbons of a list into a new list
+func SplitCollection(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	collectionId, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, ctx.UserId, models.RoleOwner)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
+	controlStruct, err := SplitController(rBody, collectionId, ctx.UserId, cType, ifUpdatedAt)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
 	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
 }
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type reorderRequest struct {
+	Bourbons []primitive.ObjectID `json:"bourbons"`
+}
+
+type entryRequest struct {
+	Tags  []string `json:"tags"`
+	Notes *string  `json:"notes"`
+}
+
+// entrySortFields maps the sort names a client can ask for to a less func -
+// proof is twice the abv so it sorts the same way
+var entrySortFields = map[string]func(a, b *models.CollectionBourbon) bool{
+	"added": func(a, b *models.CollectionBourbon) bool { return a.AddedAt < b.AddedAt },
+	"price": func(a, b *models.CollectionBourbon) bool { return a.PriceValue < b.PriceValue },
+	"proof": func(a, b *models.CollectionBourbon) bool { return a.AbvValue < b.AbvValue },
+	"abv":   func(a, b *models.CollectionBourbon) bool { return a.AbvValue < b.AbvValue },
+	"age":   func(a, b *models.CollectionBourbon) bool { return a.AgeValue < b.AgeValue },
+	"title": func(a, b *models.CollectionBourbon) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+}
+
+// applyEntryQuery filters the bourbons of a collection by the tag query params
+// (every tag must be present) and sorts them by the sort param - without a sort
+// the users custom order is kept. sort takes the form field_asc or field_desc
+func applyEntryQuery(q url.Values, cm *models.Collection) error {
+	var tags []string
+	for _, t := range q["tag"] {
+		for _, part := range strings.Split(t, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				tags = append(tags, part)
+			}
+		}
+	}
+	if len(tags) > 0 {
+		filtered := make([]*models.CollectionBourbon, 0, len(cm.Bourbons))
+		for _, e := range cm.Bourbons {
+			if e.HasTags(tags) {
+				filtered = append(filtered, e)
+			}
+		}
+		cm.Bourbons = filtered
+	}
+	if q.Get("sort") == "" || q.Get("sort") == "position" {
+		return nil
+	}
+	x := regexp.MustCompile(`^(?P<S>\w+)_(?P<D>asc|desc)$`)
+	res := x.FindStringSubmatch(q.Get("sort"))
+	if len(res) == 0 {
+		return errors.New("sort params in request were bad")
+	}
+	less, ok := entrySortFields[res[x.SubexpIndex("S")]]
+	if !ok {
+		return errors.New("sort must be one of added, price, proof, abv, age or title")
+	}
+	desc := res[x.SubexpIndex("D")] == "desc"
+	sort.SliceStable(cm.Bourbons, func(i, j int) bool {
+		if desc {
+			return less(cm.Bourbons[j], cm.Bourbons[i])
+		}
+		return less(cm.Bourbons[i], cm.Bourbons[j])
+	})
+	return nil
+}
+
+// respondControlStruct unpacks a control struct into the collection or wishlist
+// response for the cType as the auth user may see it
+func respondControlStruct(w http.ResponseWriter, cType string, cs ControlStuct, uId primitive.ObjectID) {
+	var cm models.Collection
+	var uCollRef models.UserCollectionRef
+	var uWishRef models.UserWishlistRef
+	var cr responses.CollectionResponse
+	var wr responses.WishlistResponse
+	var sr responses.StandardResponse
+	json.Unmarshal(cs.Element, &cm)
+	cm.ViewFor(uId)
+	if cType == "collection" {
+		json.Unmarshal(cs.UserRef, &uCollRef)
+		cr.Collection = &cm
+		cr.UserCollection = &uCollRef
+		sr.Respond(w, 200, "success", cr)
+	} else {
+		json.Unmarshal(cs.UserRef, &uWishRef)
+		wr.Wishlist = &cm
+		wr.UserWishlist = &uWishRef
+		sr.Respond(w, 200, "success", wr)
+	}
+}
+
+// ReorderCollectionBourbons saves a custom order for the bourbons in a
+// collection or wishlist - owners and editors only
+func ReorderCollectionBourbons(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	collectionId, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var rr reorderRequest
+	if err := json.Unmarshal(rBody, &rr); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, ctx.UserId, models.RoleOwner, models.RoleEditor)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
+	controlStruct, err := ReorderController(rr.Bourbons, collectionId, ctx.UserId, cType, ifUpdatedAt)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
+}
+
+// UpdateCollectionEntry sets the tags and notes of one bourbon in a collection
+// or wishlist - owners and editors only
+func UpdateCollectionEntry(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	var er responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	collectionId, cErr := primitive.ObjectIDFromHex(params["collectionId"])
+	bourbonId, bErr := primitive.ObjectIDFromHex(params["bourbonId"])
+	if cErr != nil || bErr != nil {
+		er.Respond(w, 400, "error", "bad request")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req entryRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	if req.Tags == nil && req.Notes == nil {
+		er.Respond(w, 400, "error", "tags or notes are required")
+		return
+	}
+	ifUpdatedAt, pErr := collectionIfMatch(r, cType, collectionId, ctx.UserId, models.RoleOwner, models.RoleEditor)
+	if pErr.Status != 0 {
+		pErr.Respond(w, pErr.Status, pErr.Message, pErr.Data["data"])
+		return
+	}
+	controlStruct, err := EntryController(req.Tags, req.Notes, collectionId, bourbonId, ctx.UserId, cType, ifUpdatedAt)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, cType, controlStruct, ctx.UserId)
+}
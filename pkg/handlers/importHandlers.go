@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/importer"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxImportBytes bounds the size of an uploaded csv
+const maxImportBytes = 2 << 20
+
+// PreviewImport reads a csv of bottles - either the raw request body or a
+// multipart "file" field - and matches every row against the catalog. Nothing
+// is written, the client confirms the rows it wants through ConfirmImport
+func PreviewImport(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	var sr responses.StandardResponse
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, fErr := r.FormFile("file")
+		if fErr != nil {
+			er.Respond(w, 400, "error", "csv file is required in the file field")
+			return
+		}
+		defer file.Close()
+		src = file
+	}
+	rows, pErr := importer.ParseCSV(src)
+	if pErr != nil {
+		er.Respond(w, 400, "error", pErr.Error())
+		return
+	}
+	// only the fields the matcher uses are needed from the catalog
+	opts := options.Find().SetProjection(bson.M{"title": 1, "distiller": 1, "bottler": 1})
+	cursor, err := bourbonsCollection.Find(context.TODO(), bson.M{}, opts)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var catalog []models.Bourbon
+	if err := cursor.All(context.TODO(), &catalog); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	matcher := importer.NewMatcher(catalog)
+	pr := responses.ImportPreviewResponse{
+		Rows:      make([]*responses.ImportPreviewRow, 0, len(rows)),
+		Total:     len(rows),
+		Unmatched: make([]*importer.Row, 0),
+	}
+	for _, row := range rows {
+		pRow := &responses.ImportPreviewRow{Row: row}
+		candidates := matcher.Match(row.Name, 4)
+		if len(candidates) > 0 {
+			pRow.Match = candidates[0]
+			pRow.Alternatives = candidates[1:]
+			pRow.Matched = candidates[0].Confidence >= importer.MatchThreshold
+		}
+		if pRow.Matched {
+			pr.Matched++
+		} else {
+			pr.Unmatched = append(pr.Unmatched, row)
+		}
+		pr.Rows = append(pr.Rows, pRow)
+	}
+	sr.Respond(w, 200, "success", pr)
+}
+
+// ConfirmImport creates a new collection or wishlist from the rows the client
+// confirmed out of an import preview
+func ConfirmImport(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.ImportConfirmRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	controlStruct, err := ImportController(req, ctx.UserId, ctx.Username)
+	if err.Status != 0 {
+		err.Respond(w, err.Status, err.Message, err.Data["data"])
+		return
+	}
+	respondControlStruct(w, req.Type, controlStruct, ctx.UserId)
+}
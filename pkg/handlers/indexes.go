@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/loginguard"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/validation"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+)
+
+// unique user fields by the name of their index - the indexes use the same
+// collation as UsernameTaken and EmailTaken so they agree on what a duplicate is
+var uniqueUserFields = map[string]string{
+	"username_ci_unique": "username",
+	"email_ci_unique":    "email",
+}
+
+// EnsureIndexes creates the indexes the handlers rely on. Creating an index
+// that already exists does nothing so it runs on every start - every index is
+// tried and the failures are returned together
+func EnsureIndexes(ctx context.Context) error {
+	steps := []func(context.Context) error{ensureUserIndexes, ensureOIDCStateIndexes}
+	if ms, ok := loginStore.(*loginguard.MongoStore); ok {
+		steps = append(steps, ms.EnsureIndexes)
+	}
+	var failed []string
+	for _, step := range steps {
+		if err := step(ctx); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func ensureUserIndexes(ctx context.Context) error {
+	models := make([]mongo.IndexModel, 0, len(uniqueUserFields))
+	for name, field := range uniqueUserFields {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: field, Value: 1}},
+			Options: options.Index().SetName(name).SetUnique(true).SetCollation(caseInsensitive),
+		})
+	}
+	_, err := usersCollection.Indexes().CreateMany(ctx, models)
+	return err
+}
+
+// ensureOIDCStateIndexes drops login states the provider never called back for
+func ensureOIDCStateIndexes(ctx context.Context) error {
+	_, err := oidcStatesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// duplicateUserFields turns a write that broke a unique user index into the
+// field error the check before the write would have given, nil for any other
+// error. It covers the writes that raced past UsernameTaken or EmailTaken
+func duplicateUserFields(err error) validation.FieldErrors {
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	msg := err.Error()
+	for name, field := range uniqueUserFields {
+		if strings.Contains(msg, name) {
+			if field == "email" {
+				return validation.FieldErrors{"email": "is already registered"}
+			}
+			return validation.FieldErrors{"username": "is already taken"}
+		}
+	}
+	return nil
+}
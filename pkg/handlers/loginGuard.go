@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/loginguard"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var loginAuditCollection = db.GetCollection(db.DB, "login_audit")
+
+// loginStore holds the failed login counters - LOGIN_GUARD_STORE=mongo shares
+// them between instances
+var loginStore = newLoginStore()
+
+func newLoginStore() loginguard.Store {
+	if os.Getenv("LOGIN_GUARD_STORE") == "mongo" {
+		return loginguard.NewMongoStore(db.GetCollection(db.DB, "login_attempts"))
+	}
+	return loginguard.NewMemoryStore()
+}
+
+// an account gets a few free guesses before backoff and is locked after ten
+// failures - an ip is allowed far more as many users can share one
+var (
+	accountLimiter = &loginguard.Limiter{
+		Store: loginStore,
+		Policy: loginguard.Policy{
+			FreeAttempts:    helpers.EnvInt("LOGIN_ACCOUNT_FREE_ATTEMPTS", 3),
+			BaseDelay:       time.Second,
+			MaxDelay:        5 * time.Minute,
+			LockoutAfter:    helpers.EnvInt("LOGIN_ACCOUNT_LOCKOUT_AFTER", 10),
+			LockoutDuration: helpers.EnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			Window:          time.Hour,
+		},
+	}
+	ipLimiter = &loginguard.Limiter{
+		Store: loginStore,
+		Policy: loginguard.Policy{
+			FreeAttempts:    helpers.EnvInt("LOGIN_IP_FREE_ATTEMPTS", 20),
+			BaseDelay:       time.Second,
+			MaxDelay:        5 * time.Minute,
+			LockoutAfter:    helpers.EnvInt("LOGIN_IP_LOCKOUT_AFTER", 100),
+			LockoutDuration: helpers.EnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			Window:          time.Hour,
+		},
+	}
+)
+
+func accountKey(email string) string {
+	return "account:" + email
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginAttempt counts a login for the email from the ip as failed on both the
+// account and the ip before the password is checked, and returns how long the
+// login has to wait when either has no attempt left. An attempt refused by the
+// ip gives back the account's. Store errors let the login through rather than
+// locking everyone out
+func loginAttempt(email, ip string) (time.Duration, bool) {
+	aWait, aLocked, aErr := accountLimiter.Attempt(context.TODO(), accountKey(email))
+	if aErr != nil {
+		log.Printf("login guard attempt not counted: %v", aErr)
+	}
+	if aWait > 0 {
+		return aWait, aLocked
+	}
+	iWait, iLocked, iErr := ipLimiter.Attempt(context.TODO(), ipKey(ip))
+	if iErr != nil {
+		log.Printf("login guard attempt not counted: %v", iErr)
+	}
+	if iWait > 0 {
+		if err := accountLimiter.Release(context.TODO(), accountKey(email)); err != nil {
+			log.Printf("login guard release failed: %v", err)
+		}
+	}
+	return iWait, iLocked
+}
+
+// loginSucceeded clears the account counter - the ip only gets back the
+// attempt it counted, so one good account cannot be used to reset an ip that
+// is guessing others
+func loginSucceeded(email, ip string) {
+	if err := accountLimiter.Reset(context.TODO(), accountKey(email)); err != nil {
+		log.Printf("login guard reset failed: %v", err)
+	}
+	if err := ipLimiter.Release(context.TODO(), ipKey(ip)); err != nil {
+		log.Printf("login guard release failed: %v", err)
+	}
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) int {
+	secs := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	return secs
+}
+
+func retryMessage(prefix string, secs int) string {
+	return fmt.Sprintf("%s - try again in %d seconds", prefix, secs)
+}
+
+// auditLogin records a login attempt - a failed write is logged and does not
+// fail the login
+func auditLogin(r *http.Request, email string, uId *primitive.ObjectID, outcome string) {
+	var a models.LoginAttempt
+	a.Build(email, helpers.ClientIP(r), r.UserAgent(), outcome)
+	a.UserID = uId
+	if _, err := loginAuditCollection.InsertOne(context.TODO(), a); err != nil {
+		log.Printf("login audit write failed: %v", err)
+	}
+}
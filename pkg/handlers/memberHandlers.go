@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+type memberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// respondMemberCollection responds with the collection a member action was
+// applied to as the auth user may see it
+func respondMemberCollection(w http.ResponseWriter, cType string, cm *models.Collection, uId primitive.ObjectID) {
+	cm.ViewFor(uId)
+	respondCollectionType(w, cType, cm)
+}
+
+// InviteCollectionMember invites another user by username to a collection or
+// wishlist with an owner, editor or viewer role - only owners can invite
+func InviteCollectionMember(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var mr memberRequest
+	json.Unmarshal(rBody, &mr)
+	if mr.Username == "" || !models.IsValidMemberRole(mr.Role) {
+		er.Respond(w, 400, "error", "username and a role of owner, editor or viewer are required")
+		return
+	}
+	var invitee models.User
+	uErr := usersCollection.FindOne(context.TODO(), bson.M{"username": mr.Username}).Decode(&invitee)
+	if uErr != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	var cm models.Collection
+	err := collectionToUse.FindOne(context.TODO(), memberRoleFilter(id, ctx.UserId, models.RoleOwner)).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	if cm.User.ID == invitee.ID {
+		er.Respond(w, 400, "error", "user already owns this "+cType)
+		return
+	}
+	for _, m := range cm.Members {
+		if m.User.ID == invitee.ID {
+			er.Respond(w, 400, "error", "user is already a member or invited")
+			return
+		}
+	}
+	member := models.CollectionMember{
+		User: &models.UserRef{
+			ID:       invitee.ID,
+			Username: invitee.Username,
+		},
+		Role:      mr.Role,
+		Status:    models.MemberInvited,
+		InvitedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	// guard against a concurrent invite of the same user - every write to the
+	// members moves updatedAt, which Last-Modified and If-Match go by
+	filter := bson.M{"_id": id, "members.user.id": bson.M{"$ne": invitee.ID}}
+	update := bson.M{"$push": bson.M{"members": member}, "$set": bson.M{"updatedAt": member.InvitedAt}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	upErr := collectionToUse.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&cm)
+	if upErr != nil {
+		er.Respond(w, 400, "error", "user is already a member or invited")
+		return
+	}
+	respondMemberCollection(w, cType, &cm, ctx.UserId)
+}
+
+// AcceptCollectionInvite turns a pending invite for the auth user into an
+// accepted membership
+func AcceptCollectionInvite(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	filter := bson.M{
+		"_id": id,
+		"members": bson.M{"$elemMatch": bson.M{
+			"user.id": ctx.UserId,
+			"status":  models.MemberInvited,
+		}},
+	}
+	updateTime := primitive.NewDateTimeFromTime(time.Now())
+	update := bson.M{"$set": bson.M{
+		"members.$.status":   models.MemberAccepted,
+		"members.$.joinedAt": updateTime,
+		"updatedAt":          updateTime,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var cm models.Collection
+	err := collectionToUse.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "no pending invite found")
+		return
+	}
+	respondMemberCollection(w, cType, &cm, ctx.UserId)
+}
+
+// UpdateCollectionMemberRole changes the role of an existing member - only
+// owners can change roles
+func UpdateCollectionMemberRole(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	memberId, mErr := primitive.ObjectIDFromHex(params["userId"])
+	if idErr != nil || mErr != nil {
+		er.Respond(w, 400, "error", "bad request")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var mr memberRequest
+	json.Unmarshal(rBody, &mr)
+	if !models.IsValidMemberRole(mr.Role) {
+		er.Respond(w, 400, "error", "role must be owner, editor or viewer")
+		return
+	}
+	filter := memberRoleFilter(id, ctx.UserId, models.RoleOwner)
+	filter["members.user.id"] = memberId
+	update := bson.M{"$set": bson.M{"members.$[m].role": mr.Role, "updatedAt": primitive.NewDateTimeFromTime(time.Now())}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"m.user.id": memberId}}})
+	var cm models.Collection
+	err := collectionToUse.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	respondMemberCollection(w, cType, &cm, ctx.UserId)
+}
+
+// RemoveCollectionMember removes a member or a pending invite - owners can
+// remove anyone and members can remove themselves to leave or decline
+func RemoveCollectionMember(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	memberId, mErr := primitive.ObjectIDFromHex(params["userId"])
+	if idErr != nil || mErr != nil {
+		er.Respond(w, 400, "error", "bad request")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	var filter bson.M
+	if memberId == ctx.UserId {
+		filter = bson.M{"_id": id}
+	} else {
+		filter = memberRoleFilter(id, ctx.UserId, models.RoleOwner)
+	}
+	filter["members.user.id"] = memberId
+	update := bson.M{
+		"$pull": bson.M{"members": bson.M{"user.id": memberId}},
+		"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var cm models.Collection
+	err := collectionToUse.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	if memberId == ctx.UserId {
+		var sr responses.StandardResponse
+		sr.Respond(w, 200, "success", "membership removed")
+		return
+	}
+	respondMemberCollection(w, cType, &cm, ctx.UserId)
+}
+
+// GetCollectionInvites returns the collections or wishlists (plural cType) the
+// auth user has been invited to but not yet accepted
+func GetCollectionInvites(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collections": collectionsCollection,
+		"wishlists":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	filter := bson.M{"members": bson.M{"$elemMatch": bson.M{
+		"user.id": ctx.UserId,
+		"status":  models.MemberInvited,
+	}}}
+	cursor, err := collectionToUse.Find(context.TODO(), filter)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	collections := make([]*models.Collection, 0)
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var collection *models.Collection
+		err := cursor.Decode(&collection)
+		if err != nil {
+			er.Respond(w, 500, "error", err.Error())
+			return
+		}
+		collection.HideFromNonOwner()
+		collections = append(collections, collection)
+	}
+	if cursErr := cursor.Err(); cursErr != nil {
+		er.Respond(w, 500, "error", cursErr.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	if cType == "collections" {
+		sr.Respond(w, 200, "success", responses.CollectionsResponse{Collections: collections})
+	} else {
+		sr.Respond(w, 200, "success", responses.WishlistsResponse{Wishlists: collections})
+	}
+}
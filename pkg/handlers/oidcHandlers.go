@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/config"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/oidc"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/validation"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var appConfig = config.Load()
+
+var oidcStatesCollection = db.GetCollection(db.DB, "oidc_states")
+
+// oidcProviders are the providers from the app config by name
+var oidcProviders = newOIDCProviders(appConfig.OIDCProviders)
+
+func newOIDCProviders(cfgs map[string]*config.OIDCProvider) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider, len(cfgs))
+	for name, cfg := range cfgs {
+		providers[name] = oidc.NewProvider(cfg)
+	}
+	return providers
+}
+
+// oidcStateTTL is how long a user has to log in at the provider
+const oidcStateTTL = 10 * time.Minute
+
+var errOIDCEmailUnverified = errors.New("an account with this email already exists - log in with your password and verify your email before signing in with this provider")
+
+// GetOIDCProviders lists the names of the providers users can log in with
+func GetOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(oidcProviders))
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.OIDCProvidersResponse{Providers: names})
+}
+
+// StartOIDCLogin stores a state, nonce and pkce verifier and responds with the
+// provider url the browser should be sent to
+func StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	provider := oidcProviders[mux.Vars(r)["provider"]]
+	if provider == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	state, sErr := helpers.GenerateRandomToken(24)
+	nonce, nErr := helpers.GenerateRandomToken(24)
+	verifier, challenge, pErr := oidc.NewPKCE()
+	if sErr != nil || nErr != nil || pErr != nil {
+		er.Respond(w, 500, "error", "could not start login")
+		return
+	}
+	authURL, aErr := provider.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if aErr != nil {
+		er.Respond(w, 502, "error", aErr.Error())
+		return
+	}
+	st := models.OIDCState{
+		State:        state,
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    primitive.NewDateTimeFromTime(time.Now().Add(oidcStateTTL)),
+	}
+	if _, err := oidcStatesCollection.InsertOne(context.TODO(), st); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.OIDCStartResponse{AuthorizationURL: authURL, State: state})
+}
+
+// OIDCCallback finishes a provider login with the code and state the provider
+// sent back. The identity is mapped to a user - an already linked user, an
+// existing user with the same verified email, or a new user - and that user
+// gets a normal session (or a 2fa challenge when 2fa is on)
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	provider := oidcProviders[mux.Vars(r)["provider"]]
+	if provider == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	q := r.URL.Query()
+	if pErr := q.Get("error"); pErr != "" {
+		er.Respond(w, 400, "error", "provider refused login: "+pErr)
+		return
+	}
+	code, state := q.Get("code"), q.Get("state")
+	if code == "" || state == "" {
+		er.Respond(w, 400, "error", "code and state are required")
+		return
+	}
+	// deleting the state on read makes it single use
+	var st models.OIDCState
+	filter := bson.M{
+		"_id":       state,
+		"provider":  provider.Name(),
+		"expiresAt": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	if err := oidcStatesCollection.FindOneAndDelete(context.TODO(), filter).Decode(&st); err != nil {
+		er.Respond(w, 400, "error", "login state is invalid or expired")
+		return
+	}
+	claims, cErr := provider.Exchange(r.Context(), code, st.CodeVerifier, st.Nonce)
+	if cErr != nil {
+		er.Respond(w, 401, "error", cErr.Error())
+		return
+	}
+	user, uErr := userForIdentity(provider.Name(), claims)
+	if uErr == errOIDCEmailUnverified {
+		er.Respond(w, 409, "error", uErr.Error())
+		return
+	}
+	if uErr != nil {
+		er.Respond(w, 400, "error", uErr.Error())
+		return
+	}
+	if user.TwoFactorEnabled() {
+		auditLogin(r, user.Email, &user.ID, models.LoginTwoFactorRequired)
+		respondTwoFactorChallenge(w, user.ID)
+		return
+	}
+	auditLogin(r, user.Email, &user.ID, models.LoginSuccess)
+	respondNewSession(w, r, user)
+}
+
+// userForIdentity finds or creates the user for a provider identity. An email
+// only links to an existing user when the provider has verified it and so
+// have we - otherwise whoever registered an address first could take over the
+// account of the real owner
+func userForIdentity(provider string, claims *oidc.Claims) (*models.User, error) {
+	var user models.User
+	linked := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": claims.Subject}}}
+	err := usersCollection.FindOne(context.TODO(), linked).Decode(&user)
+	if err == nil {
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	email, ok := validation.NormalizeEmail(claims.Email)
+	if !ok {
+		return nil, errors.New("the provider did not share a valid email")
+	}
+	identity := &models.UserIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    email,
+		LinkedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err = usersCollection.FindOne(context.TODO(), bson.M{"email": email}, opts).Decode(&user)
+	if err == nil {
+		if !claims.EmailVerified || !user.EmailVerified {
+			return nil, errOIDCEmailUnverified
+		}
+		update := bson.M{
+			"$push": bson.M{"identities": identity},
+			"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+		}
+		if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+			return nil, err
+		}
+		user.Identities = append(user.Identities, identity)
+		return &user, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return createIdentityUser(email, claims, identity)
+}
+
+// createIdentityUser registers a user from a provider identity. The password
+// is random and never shown so the account can only be reached through the
+// provider until the user sets one with a password reset - until then
+// reauthenticate takes a fresh provider login in its place
+func createIdentityUser(email string, claims *oidc.Claims, identity *models.UserIdentity) (*models.User, error) {
+	username, err := availableUsername(claims.PreferredUsername, strings.Split(email, "@")[0], claims.Name)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hashed, err := helpers.HashPassword(secret)
+	if err != nil {
+		return nil, err
+	}
+	var user models.User
+	user.Build(primitive.NewObjectID(), username, email, hashed)
+	user.EmailVerified = claims.EmailVerified
+	user.NoPassword = true
+	user.Identities = []*models.UserIdentity{identity}
+	if _, err := usersCollection.InsertOne(context.TODO(), user); err != nil {
+		return nil, err
+	}
+	if !user.EmailVerified {
+		if mErr := sendVerificationEmail(&user); mErr != nil {
+			log.Printf("verification mail to %s failed: %v", user.Email, mErr)
+		}
+	}
+	return &user, nil
+}
+
+var usernameStrip = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// availableUsername turns the first usable candidate into a valid username
+// and adds a number when it is taken
+func availableUsername(candidates ...string) (string, error) {
+	base := ""
+	for _, c := range candidates {
+		c = strings.TrimLeft(usernameStrip.ReplaceAllString(c, ""), "_.-")
+		if len(c) > 24 {
+			c = c[:24]
+		}
+		if len(c) >= 3 {
+			base = c
+			break
+		}
+	}
+	if base == "" {
+		base = "bourbonfan"
+	}
+	name := base
+	for i := 0; i < 10; i++ {
+		taken, err := UsernameTaken(context.TODO(), name, primitive.NilObjectID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s%d", base, 1000+rand.Intn(9000))
+	}
+	return "", errors.New("could not find a free username")
+}
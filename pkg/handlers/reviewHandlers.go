@@ -9,6 +9,7 @@ import (
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"io/ioutil"
 	"net/http"
@@ -26,6 +27,7 @@ func GetReviewById(w http.ResponseWriter, r *http.Request) {
 	reviewId, rErr := primitive.ObjectIDFromHex(params["id"])
 	if rErr != nil {
 		er.Respond(w, 400, "error", rErr.Error())
+		return
 	}
 	filter := bson.M{"_id": reviewId}
 	err := reviewsCollection.FindOne(context.TODO(), filter).Decode(&review)
@@ -33,7 +35,7 @@ func GetReviewById(w http.ResponseWriter, r *http.Request) {
 		er.Respond(w, 400, "error", err.Error())
 		return
 	}
-	sr.Respond(w, 200, "success", review)
+	sr.RespondConditional(w, r, review.UpdatedAt.Time(), "success", review)
 }
 
 // GetAllReviewsByFilterId is able to return all reviews based on either
@@ -78,7 +80,9 @@ func GetAllReviewsByFilterId(w http.ResponseWriter, r *http.Request) {
 	}
 	if len(reviews) > 0 {
 		rr.Reviews = reviews
-		sr.Respond(w, 200, "success", rr)
+		// only checked by the ETag - the latest updatedAt does not move when
+		// a review is deleted
+		sr.RespondConditional(w, r, time.Time{}, "success", rr)
 	} else {
 		er.Respond(w, 404, "error", "not found")
 	}
@@ -118,21 +122,27 @@ func CreateReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	review.Build(bourbon, userId, username)
-	// user ref for the review model
-	// insert the review from the request
-	_, rErr := reviewsCollection.InsertOne(context.TODO(), review)
-	if rErr != nil {
-		er.Respond(w, 500, "error", rErr.Error())
-		return
-	}
 	// review ref needed for the user model
 	rRef.ReviewID = review.ID
 	rRef.ReviewTitle = review.ReviewTitle
 	uFilter := bson.M{"_id": userId}
 	uUpdate := bson.M{"$push": bson.M{"reviews": rRef}}
-	_, uErr := usersCollection.UpdateOne(context.TODO(), uFilter, uUpdate)
-	if uErr != nil {
-		er.Respond(w, 500, "error", uErr.Error())
+	// insert the review from the request and the user ref together
+	runControllerTransaction(&er, func(sc mongo.SessionContext) error {
+		_, rErr := reviewsCollection.InsertOne(sc, review)
+		if rErr != nil {
+			er.Build(500, "error", rErr.Error())
+			return rErr
+		}
+		_, uErr := usersCollection.UpdateOne(sc, uFilter, uUpdate)
+		if uErr != nil {
+			er.Build(500, "error", uErr.Error())
+			return uErr
+		}
+		return nil
+	})
+	if er.Status != 0 {
+		er.Respond(w, er.Status, er.Message, er.Data["data"])
 		return
 	}
 	rr.Review = &review
@@ -152,20 +162,28 @@ func DeleteReview(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	userId := ctx.UserId
 	filter := bson.M{"_id": reviewId, "user.id": userId}
-	result, rErr := reviewsCollection.DeleteOne(context.TODO(), filter)
-	if rErr != nil {
-		er.Respond(w, 500, "error", rErr.Error())
-		return
-	}
-	if result.DeletedCount == 0 {
-		er.Respond(w, 404, "error", "no review with that id could be deleted")
-		return
-	}
 	rFilter := bson.M{"_id": userId, "reviews.review_id": reviewId}
 	rUpdate := bson.M{"$pull": bson.M{"reviews": bson.M{"review_id": reviewId}}}
-	_, uErr := usersCollection.UpdateOne(context.TODO(), rFilter, rUpdate)
-	if uErr != nil {
-		er.Respond(w, 500, "error", uErr.Error())
+	// the review and the user ref are removed together
+	runControllerTransaction(&er, func(sc mongo.SessionContext) error {
+		result, rErr := reviewsCollection.DeleteOne(sc, filter)
+		if rErr != nil {
+			er.Build(500, "error", rErr.Error())
+			return rErr
+		}
+		if result.DeletedCount == 0 {
+			er.Build(404, "error", "no review with that id could be deleted")
+			return errTxAborted
+		}
+		_, uErr := usersCollection.UpdateOne(sc, rFilter, rUpdate)
+		if uErr != nil {
+			er.Build(500, "error", uErr.Error())
+			return uErr
+		}
+		return nil
+	})
+	if er.Status != 0 {
+		er.Respond(w, er.Status, er.Message, er.Data["data"])
 		return
 	}
 	sr.Respond(w, 200, "success", "delete review was successful")
@@ -196,27 +214,55 @@ func UpdateReview(w http.ResponseWriter, r *http.Request) {
 		er.Respond(w, 400, "error", "bad request")
 		return
 	}
+	filter := bson.M{"_id": reviewId, "user.id": userId}
+	// with If-Match the review must still be the version the client read - the
+	// updatedAt it had goes into the update filter so a write landing in
+	// between is caught too
+	if r.Header.Get("If-Match") != "" {
+		var current models.UserReview
+		if err := reviewsCollection.FindOne(context.TODO(), filter).Decode(&current); err != nil {
+			er.Respond(w, 404, "error", "not found")
+			return
+		}
+		if !responses.IfMatch(r, responses.ETag(current)) {
+			er.Respond(w, 412, "error", "review has changed since it was read")
+			return
+		}
+		filter["updatedAt"] = current.UpdatedAt
+	}
 	// user review ref construction for the response
 	uRRef.ReviewID = reviewId
 	uRRef.ReviewTitle = rReq.ReviewTitle
 	// update time for db
 	updatedTime := primitive.NewDateTimeFromTime(time.Now())
-	filter := bson.M{"_id": reviewId, "user.id": userId}
 	update := bson.M{"$set": bson.M{"reviewTitle": rReq.ReviewTitle, "reviewScore": rReq.ReviewScore, "reviewText": rReq.ReviewText, "updatedAt": updatedTime}}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	rUpErr := reviewsCollection.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&review)
-	if rUpErr != nil {
-		er.Respond(w, 500, "error", rUpErr.Error())
-		return
-	}
 	uFilter := bson.M{"_id": userId, "reviews.review_id": reviewId}
 	uRefUpdate := bson.M{"$set": bson.M{"reviews.$.review_title": rReq.ReviewTitle, "updatedAt": updatedTime}}
-	_, uRefUpErr := usersCollection.UpdateOne(context.TODO(), uFilter, uRefUpdate)
-	if uRefUpErr != nil {
-		er.Respond(w, 500, "error", uRefUpErr.Error())
+	// the review and the title on the user ref change together
+	runControllerTransaction(&er, func(sc mongo.SessionContext) error {
+		rUpErr := reviewsCollection.FindOneAndUpdate(sc, filter, update, opts).Decode(&review)
+		if rUpErr == mongo.ErrNoDocuments && filter["updatedAt"] != nil {
+			er.Build(412, "error", "review has changed since it was read")
+			return rUpErr
+		}
+		if rUpErr != nil {
+			er.Build(500, "error", rUpErr.Error())
+			return rUpErr
+		}
+		_, uRefUpErr := usersCollection.UpdateOne(sc, uFilter, uRefUpdate)
+		if uRefUpErr != nil {
+			er.Build(500, "error", uRefUpErr.Error())
+			return uRefUpErr
+		}
+		return nil
+	})
+	if er.Status != 0 {
+		er.Respond(w, er.Status, er.Message, er.Data["data"])
 		return
 	}
 	rr.Review = &review
 	rr.UserReview = &uRRef
+	w.Header().Set("ETag", responses.ETag(review))
 	sr.Respond(w, 200, "success", rr)
 }
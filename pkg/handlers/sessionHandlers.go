@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// session settings - access tokens are short lived and refreshed with the
+// refresh token of their session, which lasts refreshTokenTTL from login
+var (
+	accessTokenTTL  = helpers.EnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL = helpers.EnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	maxSessions     = helpers.EnvInt("MAX_SESSIONS", 10)
+)
+
+// maxRotatedHashes is how many old refresh tokens of a session are remembered
+// for reuse detection
+const maxRotatedHashes = 5
+
+// newSession builds a session for the client making the request and returns
+// it with its refresh token - the token is "<session id>.<secret>" and only
+// the hash of the secret is kept
+func newSession(r *http.Request) (*models.UserTokenRef, string, error) {
+	sId, err := helpers.GenerateRandomToken(12)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := helpers.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	session := &models.UserTokenRef{
+		SessionID:   sId,
+		RefreshHash: helpers.HashToken(secret),
+		Device:      r.UserAgent(),
+		IP:          helpers.ClientIP(r),
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		LastUsedAt:  primitive.NewDateTimeFromTime(now),
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(refreshTokenTTL)),
+	}
+	return session, sId + "." + secret, nil
+}
+
+// storeSession adds a session to a user - expired sessions and tokens from
+// before sessions are dropped first and only the maxSessions most recently
+// used sessions are kept
+func storeSession(uId primitive.ObjectID, session *models.UserTokenRef) error {
+	filter := bson.M{"_id": uId}
+	prune := bson.M{"$pull": bson.M{"tokens": bson.M{"$or": []bson.M{
+		{"expiresAt": bson.M{"$lt": primitive.NewDateTimeFromTime(time.Now())}},
+		{"sessionId": bson.M{"$exists": false}},
+	}}}}
+	if _, err := usersCollection.UpdateOne(context.TODO(), filter, prune); err != nil {
+		return err
+	}
+	push := bson.M{"$push": bson.M{"tokens": bson.M{
+		"$each":  []*models.UserTokenRef{session},
+		"$sort":  bson.M{"lastUsedAt": 1},
+		"$slice": -maxSessions,
+	}}}
+	_, err := usersCollection.UpdateOne(context.TODO(), filter, push)
+	return err
+}
+
+// RefreshSession swaps a refresh token for a new access token and a new
+// refresh token. A refresh token that was already rotated away is treated as
+// stolen and the whole session is revoked
+func RefreshSession(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.RefreshTokenRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	parts := strings.SplitN(req.RefreshToken, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		er.Respond(w, 401, "error", "invalid refresh token")
+		return
+	}
+	sId, hash := parts[0], helpers.HashToken(parts[1])
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"tokens.sessionId": sId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 401, "error", "invalid refresh token")
+		return
+	}
+	session := user.Session(sId)
+	if session == nil {
+		er.Respond(w, 401, "error", "invalid refresh token")
+		return
+	}
+	revoke := bson.M{"$pull": bson.M{"tokens": bson.M{"sessionId": sId}}}
+	if session.Expired(time.Now()) {
+		usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, revoke)
+		er.Respond(w, 401, "error", "session expired")
+		return
+	}
+	if session.RefreshHash != hash {
+		for _, h := range session.RotatedHashes {
+			if h == hash {
+				usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, revoke)
+				er.Respond(w, 401, "error", "refresh token reuse detected - session revoked")
+				return
+			}
+		}
+		er.Respond(w, 401, "error", "invalid refresh token")
+		return
+	}
+	secret, sErr := helpers.GenerateRandomToken(32)
+	if sErr != nil {
+		er.Respond(w, 500, "error", sErr.Error())
+		return
+	}
+	token, exp, tErr := GenerateAuthToken(user.ID.Hex(), sId)
+	if tErr != nil {
+		er.Respond(w, 500, "error", tErr.Error())
+		return
+	}
+	// the current hash in the filter makes a concurrent refresh with the same
+	// token lose instead of forking the session
+	filter := bson.M{"_id": user.ID, "tokens": bson.M{"$elemMatch": bson.M{"sessionId": sId, "refreshHash": hash}}}
+	update := bson.M{
+		"$set": bson.M{
+			"tokens.$.refreshHash": helpers.HashToken(secret),
+			"tokens.$.lastUsedAt":  primitive.NewDateTimeFromTime(time.Now()),
+			"tokens.$.ip":          helpers.ClientIP(r),
+		},
+		"$push": bson.M{"tokens.$.rotatedHashes": bson.M{
+			"$each":  []string{hash},
+			"$slice": -maxRotatedHashes,
+		}},
+	}
+	result, uErr := usersCollection.UpdateOne(context.TODO(), filter, update)
+	if uErr != nil {
+		er.Respond(w, 500, "error", uErr.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 401, "error", "invalid refresh token")
+		return
+	}
+	ur := responses.UserTokenResponse{
+		Token:        token,
+		ExpiresAt:    primitive.NewDateTimeFromTime(exp),
+		RefreshToken: sId + "." + secret,
+		SessionID:    sId,
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", ur)
+}
+
+// GetSessions lists the active sessions of the auth user - the session of the
+// token making the request is flagged as current
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	now := time.Now()
+	sessions := make([]*responses.SessionResponse, 0, len(user.Tokens))
+	for _, t := range user.Tokens {
+		if t.SessionID == "" || t.Expired(now) {
+			continue
+		}
+		sessions = append(sessions, &responses.SessionResponse{
+			UserTokenRef: t,
+			Current:      t.SessionID == ctx.SessionID,
+		})
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.SessionsResponse{Sessions: sessions})
+}
+
+// RevokeSession ends one session of the auth user by id
+func RevokeSession(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	sId := mux.Vars(r)["sessionId"]
+	filter := bson.M{"_id": ctx.UserId, "tokens.sessionId": sId}
+	update := bson.M{"$pull": bson.M{"tokens": bson.M{"sessionId": sId}}}
+	result, err := usersCollection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 404, "error", "session not found")
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "session revoked")
+}
+
+// RevokeAllSessions ends every session of the auth user - with others=true
+// the session making the request is kept
+func RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	filter := bson.M{"_id": ctx.UserId}
+	update := bson.M{"$set": bson.M{"tokens": []*models.UserTokenRef{}}}
+	if r.URL.Query().Get("others") == "true" {
+		update = bson.M{"$pull": bson.M{"tokens": bson.M{"sessionId": bson.M{"$ne": ctx.SessionID}}}}
+	}
+	if _, err := usersCollection.UpdateOne(context.TODO(), filter, update); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "sessions revoked")
+}
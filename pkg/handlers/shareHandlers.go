@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// collectionTypePayload wraps a single collection or wishlist in the response
+// type that matches the cType so public and shared reads look like owner reads
+func collectionTypePayload(cType string, cm *models.Collection) interface{} {
+	if cType == "collection" {
+		return responses.CollectionResponse{Collection: cm}
+	}
+	return responses.WishlistResponse{Wishlist: cm}
+}
+
+// respondCollectionType responds with a collection or wishlist and its etag
+func respondCollectionType(w http.ResponseWriter, cType string, cm *models.Collection) {
+	var sr responses.StandardResponse
+	payload := collectionTypePayload(cType, cm)
+	w.Header().Set("ETag", responses.ETag(payload))
+	sr.Respond(w, 200, "success", payload)
+}
+
+// readCollectionType responds to a read of a collection or wishlist - a 304
+// when the client already has this version
+func readCollectionType(w http.ResponseWriter, r *http.Request, cType string, cm *models.Collection) {
+	var sr responses.StandardResponse
+	sr.RespondConditional(w, r, cm.UpdatedAt.Time(), "success", collectionTypePayload(cType, cm))
+}
+
+// collectionIfMatch checks the If-Match of an update against the list as the
+// user would read it. It returns the updatedAt of that version for the update
+// filter - so a write landing in between is caught too - or nil when the
+// request has no If-Match. Lists the user cannot write are left for the
+// update to refuse
+func collectionIfMatch(r *http.Request, cType string, cId, uId primitive.ObjectID, roles ...string) (*primitive.DateTime, responses.ErrorResponse) {
+	var definedError responses.ErrorResponse
+	if r.Header.Get("If-Match") == "" {
+		return nil, definedError
+	}
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var cm models.Collection
+	if err := rMap[cType].FindOne(context.TODO(), memberRoleFilter(cId, uId, roles...)).Decode(&cm); err != nil {
+		return nil, definedError
+	}
+	cm.ViewFor(uId)
+	if !responses.IfMatch(r, responses.ETag(collectionTypePayload(cType, &cm))) {
+		definedError.Build(412, "error", cType+" has changed since it was read")
+	}
+	return &cm.UpdatedAt, definedError
+}
+
+// GetPublicCollectionsType returns a paginated slice of the public collections
+// or wishlists of every user - only an api key is required
+func GetPublicCollectionsType(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collections": collectionsCollection,
+		"wishlists":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	limit := 20
+	page := 1
+	q := r.URL.Query()
+	if q.Get("page") != "" && q.Get("page") != "1" {
+		p, err := strconv.Atoi(q.Get("page"))
+		if err != nil || p < 1 {
+			er.Respond(w, 400, "error", "page must be a positive number")
+			return
+		}
+		page = p
+	}
+	skip := (page - 1) * limit
+	filter := bson.M{"private": false}
+	count, ctErr := collectionToUse.CountDocuments(context.TODO(), filter)
+	if ctErr != nil {
+		er.Respond(w, 500, "error", ctErr.Error())
+		return
+	}
+	opts := options.Find().SetSort(bson.M{"updatedAt": -1}).SetSkip(int64(skip)).SetLimit(int64(limit))
+	cursor, err := collectionToUse.Find(context.TODO(), filter, opts)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var collections []*models.Collection
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var collection *models.Collection
+		err := cursor.Decode(&collection)
+		if err != nil {
+			er.Respond(w, 500, "error", err.Error())
+			return
+		}
+		collection.HideFromNonOwner()
+		collections = append(collections, collection)
+	}
+	if cursErr := cursor.Err(); cursErr != nil {
+		er.Respond(w, 500, "error", cursErr.Error())
+		return
+	}
+	if len(collections) == 0 {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	// a list is only checked by its ETag - the latest updatedAt on the page
+	// does not move when a list drops off it
+	var sr responses.StandardResponse
+	if cType == "collections" {
+		cr := responses.CollectionsResponse{Collections: collections, TotalRecords: int(count)}
+		sr.RespondConditional(w, r, time.Time{}, "success", cr)
+	} else {
+		wr := responses.WishlistsResponse{Wishlists: collections, TotalRecords: int(count)}
+		sr.RespondConditional(w, r, time.Time{}, "success", wr)
+	}
+}
+
+// GetPublicCollectionTypeById returns a single collection or wishlist as long
+// as it is not private - only an api key is required
+func GetPublicCollectionTypeById(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	var cm models.Collection
+	filter := bson.M{"_id": id, "private": false}
+	err := collectionToUse.FindOne(context.TODO(), filter).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	cm.HideFromNonOwner()
+	if qErr := applyEntryQuery(r.URL.Query(), &cm); qErr != nil {
+		er.Respond(w, 400, "error", qErr.Error())
+		return
+	}
+	readCollectionType(w, r, cType, &cm)
+}
+
+// GetSharedCollectionType returns a collection or wishlist by one of its share
+// link tokens - private lists are readable this way until the link is revoked
+func GetSharedCollectionType(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	token := params["token"]
+	if token == "" {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	var cm models.Collection
+	filter := bson.M{"share_links.token": token}
+	err := collectionToUse.FindOne(context.TODO(), filter).Decode(&cm)
+	if err != nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	cm.HideFromNonOwner()
+	if qErr := applyEntryQuery(r.URL.Query(), &cm); qErr != nil {
+		er.Respond(w, 400, "error", qErr.Error())
+		return
+	}
+	readCollectionType(w, r, cType, &cm)
+}
+
+// CreateShareLink generates a new share link token for a collection or wishlist
+// owned by the auth user
+func CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	token, tErr := helpers.GenerateRandomToken(24)
+	if tErr != nil {
+		er.Respond(w, 500, "error", tErr.Error())
+		return
+	}
+	link := models.CollectionShareLink{
+		Token:     token,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+	filter := memberRoleFilter(id, ctx.UserId, models.RoleOwner)
+	update := bson.M{"$push": bson.M{"share_links": link}, "$set": bson.M{"updatedAt": link.CreatedAt}}
+	result, err := collectionToUse.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	var sr responses.StandardResponse
+	slr := responses.ShareLinkResponse{
+		CollectionID: id.Hex(),
+		ShareLink:    &link,
+	}
+	sr.Respond(w, 200, "success", slr)
+}
+
+// RevokeShareLink removes a share link token from a collection or wishlist
+// owned by the auth user - the token stops working immediately
+func RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	cType := params["cType"]
+	rMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	var er responses.ErrorResponse
+	collectionToUse := rMap[cType]
+	if collectionToUse == nil {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	id, idErr := primitive.ObjectIDFromHex(params["id"])
+	if idErr != nil {
+		er.Respond(w, 400, "error", idErr.Error())
+		return
+	}
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	token := params["token"]
+	filter := memberRoleFilter(id, ctx.UserId, models.RoleOwner)
+	filter["share_links.token"] = token
+	update := bson.M{
+		"$pull": bson.M{"share_links": bson.M{"token": token}},
+		"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	result, err := collectionToUse.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 404, "error", "not found")
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "share link revoked")
+}
@@ -3,12 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/importer"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
 	"time"
 )
 
@@ -17,7 +22,7 @@ type ControlStuct struct {
 	UserRef []byte
 }
 
-func bourbonUpdateValid(b []*models.Bourbon, id primitive.ObjectID, uType string) bool {
+func bourbonUpdateValid(b []*models.CollectionBourbon, id primitive.ObjectID, uType string) bool {
 	var result bool
 	if len(b) < 1 && uType == "add" {
 		return true
@@ -41,6 +46,36 @@ func bourbonUpdateValid(b []*models.Bourbon, id primitive.ObjectID, uType string
 	return result
 }
 
+// memberRoleFilter matches a collection or wishlist by id where the user is
+// either the original owner or an accepted member holding one of the roles
+func memberRoleFilter(cId, uId primitive.ObjectID, roles ...string) bson.M {
+	return bson.M{
+		"_id": cId,
+		"$or": []bson.M{
+			{"user.id": uId},
+			{"members": bson.M{"$elemMatch": bson.M{
+				"user.id": uId,
+				"status":  models.MemberAccepted,
+				"role":    bson.M{"$in": roles},
+			}}},
+		},
+	}
+}
+
+// memberAccessFilter matches every collection or wishlist a user can see -
+// the ones they own and the ones they have accepted an invite to
+func memberAccessFilter(uId primitive.ObjectID) bson.M {
+	return bson.M{
+		"$or": []bson.M{
+			{"user.id": uId},
+			{"members": bson.M{"$elemMatch": bson.M{
+				"user.id": uId,
+				"status":  models.MemberAccepted,
+			}}},
+		},
+	}
+}
+
 func (cs *ControlStuct) setControlStructUserRef(u *models.User, cId primitive.ObjectID, cType string) {
 	if cType == "collection" {
 		for _, collection := range u.Collections {
@@ -59,105 +94,188 @@ func (cs *ControlStuct) setControlStructUserRef(u *models.User, cId primitive.Ob
 	}
 }
 
-func CreateController(rBody []byte, uId primitive.ObjectID, uName string, cType string) (ControlStuct, responses.ErrorResponse) {
-	var result ControlStuct
-	var definedError responses.ErrorResponse
+// errTxAborted is returned from inside a transaction when a controller has
+// already described the failure in its error response
+var errTxAborted = errors.New("transaction aborted")
+
+// runControllerTransaction runs fn in a transaction - the error response is
+// reset on every attempt as the driver may retry, and a failure that fn did
+// not describe itself (a write or commit error) becomes a 500
+func runControllerTransaction(definedError *responses.ErrorResponse, fn func(sc mongo.SessionContext) error) {
+	tErr := db.WithTransaction(func(sc mongo.SessionContext) error {
+		*definedError = responses.ErrorResponse{}
+		return fn(sc)
+	})
+	if tErr != nil && definedError.Status == 0 {
+		definedError.Build(500, "error", tErr.Error())
+	}
+}
+
+// errUserNotFound is returned by the ref helpers when the owner of a list has
+// no user document to hold the ref
+var errUserNotFound = errors.New("user not found")
+
+// insertListWithRef inserts a new collection or wishlist and pushes the matching
+// ref, bourbons included, onto the owners user document - every path that
+// creates a list goes through here so the refs stay in sync. The marshalled
+// ref is returned for the control struct
+func insertListWithRef(sc mongo.SessionContext, cType string, cm *models.Collection) ([]byte, error) {
 	collMap := map[string]*mongo.Collection{
 		"collection": collectionsCollection,
 		"wishlist":   wishlistsCollection,
 	}
-	collectionToUse := collMap[cType]
-	if collectionToUse == nil {
-		definedError.Build(400, "error", "bad request")
-		return result, definedError
-	}
-	// collection/wishlist overlapping models
-	var cr models.CollectionRequest
-	var cm models.Collection
-	var uCRef models.UserCollectionRef
-	// wishlist unique/specific userRef
-	var uWRef models.UserWishlistRef
-	// filters, updates, and opts
-	filter := bson.M{"_id": uId}
-	json.Unmarshal(rBody, &cr)
-	cr.FillDefaults()
-	cm.Build(uId, uName, cr.Name, cr.Private)
+	var ref interface{}
+	var update bson.M
+	updateTime := primitive.NewDateTimeFromTime(time.Now())
 	if cType == "collection" {
+		var uCRef models.UserCollectionRef
 		uCRef.Build(cm.ID, cm.Name)
-		uCRefMarshal, _ := json.Marshal(uCRef)
-		result.UserRef = uCRefMarshal
+		uCRef.Bourbons = bourbonRefsFor(cm.Bourbons)
+		ref = uCRef
+		update = bson.M{"$push": bson.M{"collections": uCRef}, "$set": bson.M{"updatedAt": updateTime}}
 	} else {
+		var uWRef models.UserWishlistRef
 		uWRef.Build(cm.ID, cm.Name)
-		uWRefMarshal, _ := json.Marshal(uWRef)
-		result.UserRef = uWRefMarshal
-	}
-	typeQueryMap := map[string]bson.M{
-		"collection": {"$push": bson.M{"collections": uCRef}},
-		"wishlist":   {"$push": bson.M{"wishlists": uWRef}},
+		uWRef.Bourbons = bourbonRefsFor(cm.Bourbons)
+		ref = uWRef
+		update = bson.M{"$push": bson.M{"wishlists": uWRef}, "$set": bson.M{"updatedAt": updateTime}}
 	}
-	update := typeQueryMap[cType]
-	_, err := collectionToUse.InsertOne(context.TODO(), cm)
+	_, err := collMap[cType].InsertOne(sc, cm)
 	if err != nil {
-		definedError.Build(500, "error", err.Error())
-		return result, definedError
+		return nil, err
 	}
-	_, uErr := usersCollection.UpdateOne(context.TODO(), filter, update)
+	uResult, uErr := usersCollection.UpdateOne(sc, bson.M{"_id": cm.User.ID}, update)
 	if uErr != nil {
-		definedError.Build(500, "error", uErr.Error())
-		return result, definedError
+		return nil, uErr
 	}
-	cmM, _ := json.Marshal(cm)
-	//umM, _ := json.Marshal(u)
-	result.Element = cmM
-	//result.UserRef = umM
-
-	return result, definedError
+	if uResult.MatchedCount == 0 {
+		return nil, errUserNotFound
+	}
+	return json.Marshal(ref)
 }
 
-// DeleteController is a reusable function between collections and wishlists for deleting
-// both full collection or wishlist document as well as the user reference document
-func DeleteController(cId, uId primitive.ObjectID, cType string) responses.ErrorResponse {
-	var definedError responses.ErrorResponse
+// deleteListWithRef deletes the collection or wishlist matching filter and
+// pulls its ref from the original owners user document
+func deleteListWithRef(sc mongo.SessionContext, cType string, filter bson.M) (*models.Collection, error) {
 	collMap := map[string]*mongo.Collection{
 		"collection": collectionsCollection,
 		"wishlist":   wishlistsCollection,
 	}
-	collectionToUse := collMap[cType]
-	if collectionToUse == nil {
-		definedError.Build(400, "error", "bad request")
-		return definedError
+	var cm models.Collection
+	err := collMap[cType].FindOneAndDelete(sc, filter).Decode(&cm)
+	if err != nil {
+		return nil, err
 	}
 	typeQueryMap := map[string]bson.M{
-		"collection": {"$pull": bson.M{"collections": bson.M{"collection_id": cId}}},
-		"wishlist":   {"$pull": bson.M{"wishlists": bson.M{"wishlist_id": cId}}},
+		"collection": {"$pull": bson.M{"collections": bson.M{"collection_id": cm.ID}}},
+		"wishlist":   {"$pull": bson.M{"wishlists": bson.M{"wishlist_id": cm.ID}}},
 	}
+	_, err = usersCollection.UpdateOne(sc, bson.M{"_id": cm.User.ID}, typeQueryMap[cType])
+	if err != nil {
+		return nil, err
+	}
+	return &cm, nil
+}
 
-	update := typeQueryMap[cType]
-	filter := bson.M{"_id": cId, "user.id": uId}
-	result, err := collectionToUse.DeleteOne(context.TODO(), filter)
+// setListBourbons replaces the bourbons of a collection or wishlist and the
+// bourbon list on the owners ref in one go - it returns the updated list and
+// owner documents
+func setListBourbons(sc mongo.SessionContext, cType string, cId primitive.ObjectID, entries []*models.CollectionBourbon) (*models.Collection, *models.User, error) {
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	updateTime := primitive.NewDateTimeFromTime(time.Now())
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var cm models.Collection
+	cUpdate := bson.M{"$set": bson.M{"bourbons": entries, "updatedAt": updateTime}}
+	err := collMap[cType].FindOneAndUpdate(sc, bson.M{"_id": cId}, cUpdate, opts).Decode(&cm)
 	if err != nil {
-		definedError.Build(400, "error", err.Error())
-		return definedError
+		return nil, nil, err
+	}
+	typeQueryMap := map[string][]bson.M{
+		"collection": {{"_id": cm.User.ID, "collections.collection_id": cId}, {"$set": bson.M{"collections.$.bourbons": bourbonRefsFor(entries), "updatedAt": updateTime}}},
+		"wishlist":   {{"_id": cm.User.ID, "wishlists.wishlist_id": cId}, {"$set": bson.M{"wishlists.$.bourbons": bourbonRefsFor(entries), "updatedAt": updateTime}}},
+	}
+	var u models.User
+	err = usersCollection.FindOneAndUpdate(sc, typeQueryMap[cType][0], typeQueryMap[cType][1], opts).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &cm, &u, nil
+}
+
+func CreateController(rBody []byte, uId primitive.ObjectID, uName string, cType string) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
+		definedError.Build(400, "error", "bad request")
+		return result, definedError
+	}
+	// collection/wishlist overlapping models
+	var cr models.CollectionRequest
+	var cm models.Collection
+	json.Unmarshal(rBody, &cr)
+	cr.FillDefaults()
+	cm.Build(uId, uName, cr.Name, cr.Private)
+	// the collection document and the user ref are written together
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		ref, err := insertListWithRef(sc, cType, &cm)
+		if err == errUserNotFound {
+			definedError.Build(400, "error", "user not found")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(500, "error", err.Error())
+			return err
+		}
+		result.UserRef = ref
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
 	}
-	// we didn't find a collection with the param collection belonging to
-	// the authorized user making the request
-	if result.DeletedCount == 0 {
+	cmM, _ := json.Marshal(cm)
+	result.Element = cmM
+
+	return result, definedError
+}
+
+// DeleteController is a reusable function between collections and wishlists for deleting
+// both full collection or wishlist document as well as the user reference document
+func DeleteController(cId, uId primitive.ObjectID, cType string) responses.ErrorResponse {
+	var definedError responses.ErrorResponse
+	if cType != "collection" && cType != "wishlist" {
 		definedError.Build(400, "error", "bad request")
 		return definedError
 	}
-	// delete the collectionRef from the user document
-	// and return the updated user object doc
-	uFilter := bson.M{"_id": uId}
-	_, uUpErr := usersCollection.UpdateOne(context.TODO(), uFilter, update)
-	if uUpErr != nil {
-		definedError.Build(401, "error", "unauthorized")
+	// only an owner is allowed to delete a collection
+	filter := memberRoleFilter(cId, uId, models.RoleOwner)
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		_, err := deleteListWithRef(sc, cType, filter)
+		// we didn't find a collection with the param collection belonging to
+		// the authorized user making the request
+		if err == mongo.ErrNoDocuments {
+			definedError.Build(400, "error", "bad request")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(500, "error", err.Error())
+			return err
+		}
+		return nil
+	})
+	if definedError.Status != 0 {
 		return definedError
 	}
 	definedError.Build(0, "no errors", "delete success")
 	return definedError
 }
 
-func UpdateController(rBody []byte, cId, uId primitive.ObjectID, cType string) (ControlStuct, responses.ErrorResponse) {
+func UpdateController(rBody []byte, cId, uId primitive.ObjectID, cType string, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
 	var result ControlStuct
 	var definedError responses.ErrorResponse
 	collMap := map[string]*mongo.Collection{
@@ -171,39 +289,50 @@ func UpdateController(rBody []byte, cId, uId primitive.ObjectID, cType string) (
 	}
 	// collection models
 	var cr models.CollectionRequest
-	var cm models.Collection
-	// user filters and update bsons
-	var uFilter bson.M
-	var uUpdate bson.M
 	json.Unmarshal(rBody, &cr)
 	cr.FillDefaults()
 	// set an update time for records
 	updateTime := primitive.NewDateTimeFromTime(time.Now())
-	typeFilterMap := map[string][]bson.M{
-		"collection": {{"_id": uId, "collections.collection_id": cId}, {"$set": bson.M{"collections.$.collection_name": cr.Name, "updatedAt": updateTime}}},
-		"wishlist":   {{"_id": uId, "wishlists.wishlist_id": cId}, {"$set": bson.M{"wishlists.$.wishlist_name": cr.Name, "updatedAt": updateTime}}},
+	// filters, updates, and opts - renaming and changing privacy is for owners only
+	cFilter := memberRoleFilter(cId, uId, models.RoleOwner)
+	if ifUpdatedAt != nil {
+		cFilter["updatedAt"] = *ifUpdatedAt
 	}
-	// filters, updates, and opts
-	cFilter := bson.M{"_id": cId}
 	cUpdate := []bson.M{{"$set": bson.M{"name": cr.Name, "private": cr.Private, "updatedAt": updateTime}}}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	uFilter = typeFilterMap[cType][0]
-	uUpdate = typeFilterMap[cType][1]
 
-	err := collectionToUse.FindOneAndUpdate(context.TODO(), cFilter, cUpdate, opts).Decode(&cm)
-	if err != nil {
-		definedError.Build(400, "error", err.Error())
-		return result, definedError
-	}
-	var u models.User
-	uErr := usersCollection.FindOneAndUpdate(context.TODO(), uFilter, uUpdate, opts).Decode(&u)
-	if uErr != nil {
-		definedError.Build(400, "error", uErr.Error())
-		return result, definedError
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var cm models.Collection
+		err := collectionToUse.FindOneAndUpdate(sc, cFilter, cUpdate, opts).Decode(&cm)
+		if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return err
+		}
+		if err != nil {
+			definedError.Build(400, "error", err.Error())
+			return err
+		}
+		// the user ref lives on the original owners user document
+		typeFilterMap := map[string][]bson.M{
+			"collection": {{"_id": cm.User.ID, "collections.collection_id": cId}, {"$set": bson.M{"collections.$.collection_name": cr.Name, "updatedAt": updateTime}}},
+			"wishlist":   {{"_id": cm.User.ID, "wishlists.wishlist_id": cId}, {"$set": bson.M{"wishlists.$.wishlist_name": cr.Name, "updatedAt": updateTime}}},
+		}
+		uFilter := typeFilterMap[cType][0]
+		uUpdate := typeFilterMap[cType][1]
+		var u models.User
+		uErr := usersCollection.FindOneAndUpdate(sc, uFilter, uUpdate, opts).Decode(&u)
+		if uErr != nil {
+			definedError.Build(400, "error", uErr.Error())
+			return uErr
+		}
+		result.setControlStructUserRef(&u, cId, cType)
+		cmM, _ := json.Marshal(cm)
+		result.Element = cmM
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
 	}
-	result.setControlStructUserRef(&u, cId, cType)
-	cmM, _ := json.Marshal(cm)
-	result.Element = cmM
 	return result, definedError
 }
 
@@ -215,9 +344,8 @@ func UpdateController(rBody []byte, cId, uId primitive.ObjectID, cType string) (
 // and an error response where the status is no longer 0 (initial memory allocation)
 // this function can be reused across collection model type and wishlist model type which are
 // almost identical
-func ExistsAndUpdateController(cId, bId, uId primitive.ObjectID, action, cType string) (ControlStuct, responses.ErrorResponse) {
+func ExistsAndUpdateController(cId, bId, uId primitive.ObjectID, action, cType string, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
 	var b models.Bourbon
-	var cm models.Collection
 	var result ControlStuct
 	var definedError responses.ErrorResponse
 	bRef := models.BourbonsRef{
@@ -245,54 +373,843 @@ func ExistsAndUpdateController(cId, bId, uId primitive.ObjectID, action, cType s
 		operator = "$pull"
 	}
 	updateTime := primitive.NewDateTimeFromTime(time.Now())
-	// collection filter and collection update
-	filter := bson.M{"_id": cId, "user.id": uId}
-	cUpdate := bson.M{operator: bson.M{"bourbons": b}}
+	// collection filter and collection update - owners and editors can
+	// change the bourbons in a collection
+	filter := memberRoleFilter(cId, uId, models.RoleOwner, models.RoleEditor)
+	if ifUpdatedAt != nil {
+		filter["updatedAt"] = *ifUpdatedAt
+	}
+	cUpdate := bson.M{
+		operator: bson.M{"bourbons": models.NewCollectionBourbon(b)},
+		"$set":   bson.M{"updatedAt": updateTime},
+	}
+	if action != "add" {
+		// pull by id so a changed catalog copy still matches
+		cUpdate[operator] = bson.M{"bourbons": bson.M{"_id": bId}}
+	}
 	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	// user filter and user ref updates
-	typeQueryMap := map[string][]bson.M{
-		"collection": {{"_id": uId, "collections.collection_id": cId}, {operator: bson.M{"collections.$.bourbons": bRef}}},
-		"wishlist":   {{"_id": uId, "wishlists.wishlist_id": cId}, {operator: bson.M{"wishlists.$.bourbons": bRef}}},
-	}
-	uFilter := typeQueryMap[cType][0]
-	uUpdate := typeQueryMap[cType][1]
-	dErr := collectionToUse.FindOne(context.TODO(), filter).Decode(&cm)
-	if dErr != nil {
-		definedError.Build(400, "error", dErr.Error())
-		return result, definedError
+	// the existence checks and both writes share one transaction so a
+	// concurrent change can't slip in between the check and the update
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var cm models.Collection
+		dErr := collectionToUse.FindOne(sc, filter).Decode(&cm)
+		if dErr == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return errTxAborted
+		}
+		if dErr != nil {
+			definedError.Build(400, "error", dErr.Error())
+			return dErr
+		}
+		if !bourbonUpdateValid(cm.Bourbons, b.ID, action) {
+			definedError.Build(400, "error", "action not valid")
+			return errTxAborted
+		}
+		// user filter and user ref updates - the ref lives on the original owners
+		// user document which may not be the auth user
+		typeQueryMap := map[string][]bson.M{
+			"collection": {{"_id": cm.User.ID, "collections.collection_id": cId}, {operator: bson.M{"collections.$.bourbons": bRef}, "$set": bson.M{"updatedAt": updateTime}}},
+			"wishlist":   {{"_id": cm.User.ID, "wishlists.wishlist_id": cId}, {operator: bson.M{"wishlists.$.bourbons": bRef}, "$set": bson.M{"updatedAt": updateTime}}},
+		}
+		uFilter := typeQueryMap[cType][0]
+		uUpdate := typeQueryMap[cType][1]
+		// determine the type of update needed based on action - default is adding bourbon
+		cUpErr := collectionToUse.FindOneAndUpdate(sc, bson.M{"_id": cId}, cUpdate, opts).Decode(&cm)
+		if cUpErr != nil {
+			definedError.Build(400, "error", cUpErr.Error())
+			return cUpErr
+		}
+		// find and update user based on collection type and action updates determined above
+		var u models.User
+		uErr := usersCollection.FindOneAndUpdate(sc, uFilter, uUpdate, opts).Decode(&u)
+		if uErr != nil {
+			definedError.Build(400, "error", uErr.Error())
+			return uErr
+		}
+		result.setControlStructUserRef(&u, cId, cType)
+		cMm, _ := json.Marshal(cm)
+		result.Element = cMm
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	return result, definedError
+}
+
+func findUserCollectionRef(u *models.User, cId primitive.ObjectID) *models.UserCollectionRef {
+	for _, collection := range u.Collections {
+		if collection.CollectionID == cId {
+			return collection
+		}
+	}
+	return nil
+}
+
+func findUserWishlistRef(u *models.User, wId primitive.ObjectID) *models.UserWishlistRef {
+	for _, wishlist := range u.Wishlists {
+		if wishlist.WishlistID == wId {
+			return wishlist
+		}
+	}
+	return nil
+}
+
+// AcquireController moves a bourbon out of a wishlist and into a collection in a
+// single transaction - both list documents and both user refs change together or
+// not at all. The auth user must be an owner or editor of both lists
+func AcquireController(wId, cId, bId, uId primitive.ObjectID) (responses.AcquireResponse, responses.ErrorResponse) {
+	var result responses.AcquireResponse
+	var definedError responses.ErrorResponse
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		result = responses.AcquireResponse{}
+		var b models.Bourbon
+		var wm models.Collection
+		var cm models.Collection
+		err := bourbonsCollection.FindOne(sc, bson.M{"_id": bId}).Decode(&b)
+		if err != nil {
+			definedError.Build(400, "error", "bourbon not found")
+			return errTxAborted
+		}
+		wFilter := memberRoleFilter(wId, uId, models.RoleOwner, models.RoleEditor)
+		err = wishlistsCollection.FindOne(sc, wFilter).Decode(&wm)
+		if err != nil {
+			definedError.Build(400, "error", "wishlist not found")
+			return errTxAborted
+		}
+		cFilter := memberRoleFilter(cId, uId, models.RoleOwner, models.RoleEditor)
+		err = collectionsCollection.FindOne(sc, cFilter).Decode(&cm)
+		if err != nil {
+			definedError.Build(400, "error", "collection not found")
+			return errTxAborted
+		}
+		if !bourbonUpdateValid(wm.Bourbons, bId, "delete") {
+			definedError.Build(400, "error", "bourbon is not on the wishlist")
+			return errTxAborted
+		}
+		if !bourbonUpdateValid(cm.Bourbons, bId, "add") {
+			definedError.Build(400, "error", "bourbon is already in the collection")
+			return errTxAborted
+		}
+		// the tags and notes from the wishlist entry carry over to the collection
+		entry := models.NewCollectionBourbon(b)
+		for _, we := range wm.Bourbons {
+			if we.ID == bId {
+				entry.Tags = we.Tags
+				entry.Notes = we.Notes
+			}
+		}
+		updateTime := primitive.NewDateTimeFromTime(time.Now())
+		bRef := models.BourbonsRef{BourbonID: bId}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		// the list documents
+		wUpdate := bson.M{
+			"$pull": bson.M{"bourbons": bson.M{"_id": bId}},
+			"$set":  bson.M{"updatedAt": updateTime},
+		}
+		err = wishlistsCollection.FindOneAndUpdate(sc, bson.M{"_id": wId}, wUpdate, opts).Decode(&wm)
+		if err != nil {
+			return err
+		}
+		cUpdate := bson.M{
+			"$push": bson.M{"bourbons": entry},
+			"$set":  bson.M{"updatedAt": updateTime},
+		}
+		err = collectionsCollection.FindOneAndUpdate(sc, bson.M{"_id": cId}, cUpdate, opts).Decode(&cm)
+		if err != nil {
+			return err
+		}
+		// the user refs live on the owners of each list which may be different users
+		var wu models.User
+		wuFilter := bson.M{"_id": wm.User.ID, "wishlists.wishlist_id": wId}
+		wuUpdate := bson.M{
+			"$pull": bson.M{"wishlists.$.bourbons": bRef},
+			"$set":  bson.M{"updatedAt": updateTime},
+		}
+		err = usersCollection.FindOneAndUpdate(sc, wuFilter, wuUpdate, opts).Decode(&wu)
+		if err != nil {
+			return err
+		}
+		var cu models.User
+		cuFilter := bson.M{"_id": cm.User.ID, "collections.collection_id": cId}
+		cuUpdate := bson.M{
+			"$push": bson.M{"collections.$.bourbons": bRef},
+			"$set":  bson.M{"updatedAt": updateTime},
+		}
+		err = usersCollection.FindOneAndUpdate(sc, cuFilter, cuUpdate, opts).Decode(&cu)
+		if err != nil {
+			return err
+		}
+		wm.ViewFor(uId)
+		cm.ViewFor(uId)
+		result.Wishlist = &wm
+		result.Collection = &cm
+		result.UserWishlist = findUserWishlistRef(&wu, wId)
+		result.UserCollection = findUserCollectionRef(&cu, cId)
+		return nil
+	})
+	if definedError.Status != 0 {
+		return responses.AcquireResponse{}, definedError
+	}
+	return result, definedError
+}
+
+// maxEntryTags and maxTagLength bound the tags a user can put on one entry
+const (
+	maxEntryTags = 20
+	maxTagLength = 32
+)
+
+// normalizeTags trims tags, drops empties and duplicates (ignoring case) and
+// enforces the per entry limits
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if len(t) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", t, maxTagLength)
+		}
+		key := strings.ToLower(t)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, t)
+	}
+	if len(result) > maxEntryTags {
+		return nil, fmt.Errorf("an entry can have at most %d tags", maxEntryTags)
+	}
+	return result, nil
+}
+
+// bourbonRefsFor builds the user ref bourbon list in the same order as the entries
+func bourbonRefsFor(entries []*models.CollectionBourbon) []*models.BourbonsRef {
+	refs := make([]*models.BourbonsRef, 0, len(entries))
+	for _, e := range entries {
+		refs = append(refs, &models.BourbonsRef{BourbonID: e.ID})
+	}
+	return refs
+}
+
+// ReorderController sets a custom order on the bourbons in a collection or
+// wishlist - the order must name every bourbon in the list exactly once. The
+// owners user ref is reordered in the same transaction
+func ReorderController(order []primitive.ObjectID, cId, uId primitive.ObjectID, cType string, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
 	}
-	if !bourbonUpdateValid(cm.Bourbons, b.ID, action) {
-		definedError.Build(400, "error", "action not valid")
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
 		return result, definedError
 	}
-	// update the updatedAt
-	tUpdate := bson.M{"$set": bson.M{"updatedAt": updateTime}}
-	_, tUp := collectionToUse.UpdateOne(context.TODO(), filter, tUpdate)
-	if tUp != nil {
-		definedError.Build(500, "error", "update failed")
+	filter := memberRoleFilter(cId, uId, models.RoleOwner, models.RoleEditor)
+	if ifUpdatedAt != nil {
+		filter["updatedAt"] = *ifUpdatedAt
+	}
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var cm models.Collection
+		err := collectionToUse.FindOne(sc, filter).Decode(&cm)
+		if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(400, "error", err.Error())
+			return err
+		}
+		if len(order) != len(cm.Bourbons) {
+			definedError.Build(400, "error", "order must list every bourbon in the "+cType+" exactly once")
+			return errTxAborted
+		}
+		byId := make(map[primitive.ObjectID]*models.CollectionBourbon, len(cm.Bourbons))
+		for _, e := range cm.Bourbons {
+			byId[e.ID] = e
+		}
+		reordered := make([]*models.CollectionBourbon, 0, len(order))
+		for _, id := range order {
+			e, ok := byId[id]
+			if !ok {
+				definedError.Build(400, "error", "order must list every bourbon in the "+cType+" exactly once")
+				return errTxAborted
+			}
+			delete(byId, id)
+			reordered = append(reordered, e)
+		}
+		updated, u, err := setListBourbons(sc, cType, cId, reordered)
+		if err != nil {
+			return err
+		}
+		result.setControlStructUserRef(u, cId, cType)
+		cmM, _ := json.Marshal(updated)
+		result.Element = cmM
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	return result, definedError
+}
+
+// EntryController sets the tags and/or notes on a single bourbon entry in a
+// collection or wishlist - a nil field is left as it is
+func EntryController(tags []string, notes *string, cId, bId, uId primitive.ObjectID, cType string, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
 		return result, definedError
 	}
-	// determine the type of update needed based on action - default is adding bourbon
-	cUpErr := collectionToUse.FindOneAndUpdate(context.TODO(), filter, cUpdate, opts).Decode(&cm)
-	if cUpErr != nil {
-		definedError.Build(400, "error", cUpErr.Error())
+	set := bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())}
+	if tags != nil {
+		normalized, tErr := normalizeTags(tags)
+		if tErr != nil {
+			definedError.Build(400, "error", tErr.Error())
+			return result, definedError
+		}
+		set["bourbons.$[e].tags"] = normalized
+	}
+	if notes != nil {
+		set["bourbons.$[e].notes"] = strings.TrimSpace(*notes)
+	}
+	filter := memberRoleFilter(cId, uId, models.RoleOwner, models.RoleEditor)
+	filter["bourbons._id"] = bId
+	if ifUpdatedAt != nil {
+		filter["updatedAt"] = *ifUpdatedAt
+	}
+	// the filter also matches the members array, so the positional $ could
+	// point at a member - the entry is picked out by an array filter instead
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"e._id": bId}}})
+	var cm models.Collection
+	err := collectionToUse.FindOneAndUpdate(context.TODO(), filter, bson.M{"$set": set}, opts).Decode(&cm)
+	if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+		definedError.Build(412, "error", cType+" has changed since it was read")
 		return result, definedError
 	}
-	// update the user updatedAt
-	_, utUp := usersCollection.UpdateOne(context.TODO(), uFilter, tUpdate)
-	if utUp != nil {
-		definedError.Build(500, "error", "update failed")
+	if err != nil {
+		definedError.Build(404, "error", "bourbon not found in "+cType)
 		return result, definedError
 	}
-	// find and update user based on collection type and action updates determined above
 	var u models.User
-	uErr := usersCollection.FindOneAndUpdate(context.TODO(), uFilter, uUpdate, opts).Decode(&u)
-	if uErr != nil {
-		definedError.Build(400, "error", uErr.Error())
+	uErr := usersCollection.FindOne(context.TODO(), bson.M{"_id": cm.User.ID}).Decode(&u)
+	if uErr == nil {
+		result.setControlStructUserRef(&u, cId, cType)
+	}
+	cmM, _ := json.Marshal(cm)
+	result.Element = cmM
+	return result, definedError
+}
+
+// copyEntries makes copies of entries so a new list never shares the tag
+// slices of the list it came from
+func copyEntries(entries []*models.CollectionBourbon) []*models.CollectionBourbon {
+	copies := make([]*models.CollectionBourbon, 0, len(entries))
+	for _, e := range entries {
+		c := *e
+		c.Tags = append([]string(nil), e.Tags...)
+		copies = append(copies, &c)
+	}
+	return copies
+}
+
+// CloneController duplicates a collection or wishlist into a new list owned by
+// the auth user - the source can be any list the user can read. The request body
+// can name the copy and set its privacy, otherwise the source values are used
+func CloneController(rBody []byte, cId, uId primitive.ObjectID, uName, cType string) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
+		return result, definedError
+	}
+	var req struct {
+		Name    string `json:"name"`
+		Private *bool  `json:"private"`
+	}
+	json.Unmarshal(rBody, &req)
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var source models.Collection
+		err := collectionToUse.FindOne(sc, bson.M{"_id": cId}).Decode(&source)
+		if err != nil || (source.Private && source.RoleFor(uId) == "") {
+			definedError.Build(404, "error", "not found")
+			return errTxAborted
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = "Copy of " + source.Name
+		}
+		private := source.Private
+		if req.Private != nil {
+			private = *req.Private
+		}
+		var cm models.Collection
+		cm.Build(uId, uName, name, private)
+		cm.Bourbons = copyEntries(source.Bourbons)
+		ref, err := insertListWithRef(sc, cType, &cm)
+		if err != nil {
+			return err
+		}
+		result.UserRef = ref
+		cmM, _ := json.Marshal(cm)
+		result.Element = cmM
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	return result, definedError
+}
+
+// MergeController moves the bourbons of the source list into the target list,
+// skipping any bourbon the target already has, and then deletes the source -
+// the auth user must own both lists. The target keeps its own entries as they are
+func MergeController(targetId, sourceId, uId primitive.ObjectID, cType string, keepSource bool, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
+		return result, definedError
+	}
+	if targetId == sourceId {
+		definedError.Build(400, "error", "can not merge a "+cType+" into itself")
+		return result, definedError
+	}
+	tFilter := memberRoleFilter(targetId, uId, models.RoleOwner)
+	if ifUpdatedAt != nil {
+		tFilter["updatedAt"] = *ifUpdatedAt
+	}
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var target models.Collection
+		var source models.Collection
+		err := collectionToUse.FindOne(sc, tFilter).Decode(&target)
+		if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(404, "error", "target "+cType+" not found")
+			return errTxAborted
+		}
+		err = collectionToUse.FindOne(sc, memberRoleFilter(sourceId, uId, models.RoleOwner)).Decode(&source)
+		if err != nil {
+			definedError.Build(404, "error", "source "+cType+" not found")
+			return errTxAborted
+		}
+		if target.User.ID != source.User.ID {
+			definedError.Build(400, "error", "both lists must belong to the same user")
+			return errTxAborted
+		}
+		merged := target.Bourbons
+		have := make(map[primitive.ObjectID]bool, len(merged))
+		for _, e := range merged {
+			have[e.ID] = true
+		}
+		for _, e := range copyEntries(source.Bourbons) {
+			if have[e.ID] {
+				continue
+			}
+			have[e.ID] = true
+			merged = append(merged, e)
+		}
+		updated, u, err := setListBourbons(sc, cType, targetId, merged)
+		if err != nil {
+			return err
+		}
+		if !keepSource {
+			if _, err := deleteListWithRef(sc, cType, bson.M{"_id": sourceId}); err != nil {
+				return err
+			}
+			// the user document changed again once the source ref was pulled
+			if err := usersCollection.FindOne(sc, bson.M{"_id": u.ID}).Decode(u); err != nil {
+				return err
+			}
+		}
+		result.setControlStructUserRef(u, targetId, cType)
+		cmM, _ := json.Marshal(updated)
+		result.Element = cmM
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	return result, definedError
+}
+
+// SplitController moves the selected bourbons out of a list into a new list of
+// the same type owned by the same user - the auth user must own the source
+func SplitController(rBody []byte, cId, uId primitive.ObjectID, cType string, ifUpdatedAt *primitive.DateTime) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
+		return result, definedError
+	}
+	var req struct {
+		Bourbons []primitive.ObjectID `json:"bourbons"`
+		Name     string               `json:"name"`
+		Private  *bool                `json:"private"`
+	}
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		definedError.Build(400, "error", err.Error())
+		return result, definedError
+	}
+	if len(req.Bourbons) == 0 {
+		definedError.Build(400, "error", "bourbons to split out are required")
+		return result, definedError
+	}
+	sFilter := memberRoleFilter(cId, uId, models.RoleOwner)
+	if ifUpdatedAt != nil {
+		sFilter["updatedAt"] = *ifUpdatedAt
+	}
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		var source models.Collection
+		err := collectionToUse.FindOne(sc, sFilter).Decode(&source)
+		if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(404, "error", "not found")
+			return errTxAborted
+		}
+		selected := make(map[primitive.ObjectID]bool, len(req.Bourbons))
+		for _, id := range req.Bourbons {
+			selected[id] = true
+		}
+		keep := make([]*models.CollectionBourbon, 0, len(source.Bourbons))
+		moved := make([]*models.CollectionBourbon, 0, len(selected))
+		for _, e := range source.Bourbons {
+			if selected[e.ID] {
+				moved = append(moved, e)
+				delete(selected, e.ID)
+			} else {
+				keep = append(keep, e)
+			}
+		}
+		if len(selected) > 0 {
+			definedError.Build(400, "error", "every bourbon to split out must be in the "+cType)
+			return errTxAborted
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = source.Name + " (split)"
+		}
+		private := source.Private
+		if req.Private != nil {
+			private = *req.Private
+		}
+		if _, _, err := setListBourbons(sc, cType, cId, keep); err != nil {
+			return err
+		}
+		var cm models.Collection
+		cm.Build(source.User.ID, source.User.Username, name, private)
+		cm.Bourbons = moved
+		ref, err := insertListWithRef(sc, cType, &cm)
+		if err != nil {
+			return err
+		}
+		result.UserRef = ref
+		cmM, _ := json.Marshal(cm)
+		result.Element = cmM
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	return result, definedError
+}
+
+// maxBulkOperations bounds a single bulk request
+const maxBulkOperations = 500
+
+// bulk item statuses
+const (
+	bulkApplied = "applied"
+	bulkSkipped = "skipped"
+	bulkFailed  = "failed"
+)
+
+// BulkBourbonsController validates every add/delete operation up front with a
+// single catalog query, then writes the resulting bourbon list and the owners
+// ref in one transaction. Operations that would do nothing are skipped and bad
+// ones fail - with atomic set any failure rejects the whole batch
+func BulkBourbonsController(ops []*models.BulkBourbonOperation, cId, uId primitive.ObjectID, cType string, atomic bool, ifUpdatedAt *primitive.DateTime) (responses.BulkBourbonsResponse, responses.ErrorResponse) {
+	var result responses.BulkBourbonsResponse
+	var definedError responses.ErrorResponse
+	collMap := map[string]*mongo.Collection{
+		"collection": collectionsCollection,
+		"wishlist":   wishlistsCollection,
+	}
+	collectionToUse := collMap[cType]
+	if collectionToUse == nil {
+		definedError.Build(400, "error", "bad request")
+		return result, definedError
+	}
+	if len(ops) == 0 || len(ops) > maxBulkOperations {
+		definedError.Build(400, "error", fmt.Sprintf("between 1 and %d operations are required", maxBulkOperations))
+		return result, definedError
+	}
+	// parse every id and look all of them up in the catalog at once
+	ids := make([]primitive.ObjectID, len(ops))
+	lookup := make([]primitive.ObjectID, 0, len(ops))
+	for i, op := range ops {
+		if op == nil {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(op.BourbonID)
+		if err == nil {
+			ids[i] = id
+			lookup = append(lookup, id)
+		}
+	}
+	catalog := make(map[primitive.ObjectID]models.Bourbon, len(lookup))
+	cursor, err := bourbonsCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": lookup}})
+	if err != nil {
+		definedError.Build(500, "error", err.Error())
+		return result, definedError
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var b models.Bourbon
+		if err := cursor.Decode(&b); err != nil {
+			definedError.Build(500, "error", err.Error())
+			return result, definedError
+		}
+		catalog[b.ID] = b
+	}
+	if err := cursor.Err(); err != nil {
+		definedError.Build(500, "error", err.Error())
+		return result, definedError
+	}
+	filter := memberRoleFilter(cId, uId, models.RoleOwner, models.RoleEditor)
+	if ifUpdatedAt != nil {
+		filter["updatedAt"] = *ifUpdatedAt
+	}
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		result = responses.BulkBourbonsResponse{Results: make([]*responses.BulkItemResult, 0, len(ops))}
+		var cm models.Collection
+		err := collectionToUse.FindOne(sc, filter).Decode(&cm)
+		if err == mongo.ErrNoDocuments && ifUpdatedAt != nil {
+			definedError.Build(412, "error", cType+" has changed since it was read")
+			return errTxAborted
+		}
+		if err != nil {
+			definedError.Build(400, "error", err.Error())
+			return err
+		}
+		// apply the operations in order to an in memory copy of the list
+		entries := cm.Bourbons
+		present := make(map[primitive.ObjectID]bool, len(entries))
+		for _, e := range entries {
+			present[e.ID] = true
+		}
+		removed := make(map[primitive.ObjectID]bool)
+		for i, op := range ops {
+			item := &responses.BulkItemResult{Status: bulkApplied}
+			result.Results = append(result.Results, item)
+			if op == nil {
+				item.Status, item.Message = bulkFailed, "empty operation"
+				continue
+			}
+			item.BourbonID, item.Action = op.BourbonID, op.Action
+			switch {
+			case op.Action != "add" && op.Action != "delete":
+				item.Status, item.Message = bulkFailed, "action must be add or delete"
+			case ids[i].IsZero():
+				item.Status, item.Message = bulkFailed, "invalid bourbon id"
+			case op.Action == "add" && present[ids[i]]:
+				item.Status, item.Message = bulkSkipped, "already in "+cType
+			case op.Action == "delete" && !present[ids[i]]:
+				item.Status, item.Message = bulkSkipped, "not in "+cType
+			case op.Action == "add":
+				b, ok := catalog[ids[i]]
+				if !ok {
+					item.Status, item.Message = bulkFailed, "bourbon not found"
+					break
+				}
+				present[ids[i]] = true
+				if removed[ids[i]] {
+					// deleted earlier in this batch - the entry is still in place
+					delete(removed, ids[i])
+					break
+				}
+				entries = append(entries, models.NewCollectionBourbon(b))
+			default:
+				present[ids[i]] = false
+				removed[ids[i]] = true
+			}
+			switch item.Status {
+			case bulkApplied:
+				result.Applied++
+			case bulkSkipped:
+				result.Skipped++
+			default:
+				result.Failed++
+			}
+		}
+		if atomic && result.Failed > 0 {
+			definedError.Build(400, "error", result.Results)
+			return errTxAborted
+		}
+		if result.Applied == 0 {
+			var u models.User
+			if err := usersCollection.FindOne(sc, bson.M{"_id": cm.User.ID}).Decode(&u); err != nil {
+				return err
+			}
+			setBulkResultList(&result, cType, &cm, &u, uId)
+			return nil
+		}
+		final := make([]*models.CollectionBourbon, 0, len(entries))
+		for _, e := range entries {
+			if removed[e.ID] {
+				continue
+			}
+			final = append(final, e)
+		}
+		updated, u, err := setListBourbons(sc, cType, cId, final)
+		if err != nil {
+			return err
+		}
+		setBulkResultList(&result, cType, updated, u, uId)
+		return nil
+	})
+	if definedError.Status != 0 {
+		return responses.BulkBourbonsResponse{}, definedError
+	}
+	return result, definedError
+}
+
+// setBulkResultList fills the list, as the auth user may see it, and the
+// owners ref for the cType
+func setBulkResultList(result *responses.BulkBourbonsResponse, cType string, cm *models.Collection, u *models.User, uId primitive.ObjectID) {
+	cm.ViewFor(uId)
+	if cType == "collection" {
+		result.Collection = cm
+		result.UserCollection = findUserCollectionRef(u, cm.ID)
+	} else {
+		result.Wishlist = cm
+		result.UserWishlist = findUserWishlistRef(u, cm.ID)
+	}
+}
+
+// ImportController creates a new collection or wishlist for the auth user from
+// the rows of a confirmed import - rows naming the same bourbon are kept once
+func ImportController(req models.ImportConfirmRequest, uId primitive.ObjectID, uName string) (ControlStuct, responses.ErrorResponse) {
+	var result ControlStuct
+	var definedError responses.ErrorResponse
+	cType := req.Type
+	if cType != "collection" && cType != "wishlist" {
+		definedError.Build(400, "error", "type must be collection or wishlist")
+		return result, definedError
+	}
+	if len(req.Rows) == 0 || len(req.Rows) > importer.MaxRows {
+		definedError.Build(400, "error", fmt.Sprintf("between 1 and %d rows are required", importer.MaxRows))
+		return result, definedError
+	}
+	ids := make([]primitive.ObjectID, 0, len(req.Rows))
+	for i, row := range req.Rows {
+		if row == nil {
+			definedError.Build(400, "error", fmt.Sprintf("row %d is empty", i+1))
+			return result, definedError
+		}
+		id, err := primitive.ObjectIDFromHex(row.BourbonID)
+		if err != nil {
+			definedError.Build(400, "error", fmt.Sprintf("row %d has an invalid bourbon id", i+1))
+			return result, definedError
+		}
+		ids = append(ids, id)
+	}
+	catalog := make(map[primitive.ObjectID]models.Bourbon, len(ids))
+	cursor, err := bourbonsCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		definedError.Build(500, "error", err.Error())
+		return result, definedError
+	}
+	defer cursor.Close(context.TODO())
+	for cursor.Next(context.TODO()) {
+		var b models.Bourbon
+		if err := cursor.Decode(&b); err != nil {
+			definedError.Build(500, "error", err.Error())
+			return result, definedError
+		}
+		catalog[b.ID] = b
+	}
+	if err := cursor.Err(); err != nil {
+		definedError.Build(500, "error", err.Error())
 		return result, definedError
 	}
-	result.setControlStructUserRef(&u, cId, cType)
-	cMm, _ := json.Marshal(cm)
-	result.Element = cMm
+	entries := make([]*models.CollectionBourbon, 0, len(ids))
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for i, row := range req.Rows {
+		b, ok := catalog[ids[i]]
+		if !ok {
+			definedError.Build(400, "error", fmt.Sprintf("row %d names a bourbon that is not in the catalog", i+1))
+			return result, definedError
+		}
+		if seen[b.ID] {
+			continue
+		}
+		seen[b.ID] = true
+		entry := models.NewCollectionBourbon(b)
+		if row.PricePaid != nil && *row.PricePaid >= 0 {
+			entry.PricePaid = *row.PricePaid
+		}
+		if row.Date != "" {
+			d, dErr := importer.ParseDate(row.Date)
+			if dErr != nil {
+				definedError.Build(400, "error", fmt.Sprintf("row %d date could not be read", i+1))
+				return result, definedError
+			}
+			entry.PurchasedAt = primitive.NewDateTimeFromTime(d)
+		}
+		entries = append(entries, entry)
+	}
+	cr := models.CollectionRequest{Name: strings.TrimSpace(req.Name), Private: req.Private}
+	cr.FillDefaults()
+	var cm models.Collection
+	cm.Build(uId, uName, cr.Name, cr.Private)
+	cm.Bourbons = entries
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		ref, err := insertListWithRef(sc, cType, &cm)
+		if err != nil {
+			return err
+		}
+		result.UserRef = ref
+		return nil
+	})
+	if definedError.Status != 0 {
+		return ControlStuct{}, definedError
+	}
+	cmM, _ := json.Marshal(cm)
+	result.Element = cmM
 	return result, definedError
 }
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/totp"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// challengeTTL is how long the second step of a 2fa login can take
+const challengeTTL = 5 * time.Minute
+
+// challengeAudience marks a jwt as a 2fa challenge - challenge tokens carry no
+// session id so Auth never accepts them as access tokens
+const challengeAudience = "2fa-challenge"
+
+const (
+	totpIssuer        = "helloBourbon"
+	recoveryCodeCount = 10
+)
+
+var errInvalidChallenge = errors.New("challenge token is invalid or expired")
+
+func totpKey(uId primitive.ObjectID) string {
+	return "totp:" + uId.Hex()
+}
+
+func generateChallengeToken(uId primitive.ObjectID) (string, time.Time, error) {
+	t := time.Now()
+	exp := t.Add(challengeTTL)
+	claims := JWTCustomClaims{
+		UserId: uId.Hex(),
+		StandardClaims: jwt.StandardClaims{
+			Audience:  challengeAudience,
+			Issuer:    "helloBourbon",
+			IssuedAt:  t.Unix(),
+			ExpiresAt: exp.Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err := token.SignedString([]byte(jwtSec))
+	return tokenString, exp, err
+}
+
+func parseChallengeToken(tokenString string) (primitive.ObjectID, error) {
+	var claims JWTCustomClaims
+	token, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errInvalidChallenge
+		}
+		return []byte(jwtSec), nil
+	})
+	if err != nil || !token.Valid || !claims.VerifyAudience(challengeAudience, true) {
+		return primitive.NilObjectID, errInvalidChallenge
+	}
+	return primitive.ObjectIDFromHex(claims.UserId)
+}
+
+func respondTwoFactorChallenge(w http.ResponseWriter, uId primitive.ObjectID) {
+	var er responses.ErrorResponse
+	token, exp, err := generateChallengeToken(uId)
+	if err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.TwoFactorChallengeResponse{
+		TwoFactorRequired: true,
+		ChallengeToken:    token,
+		ExpiresAt:         primitive.NewDateTimeFromTime(exp),
+	})
+}
+
+// normalizeRecoveryCode lets codes be typed without the dash or in any case
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// newRecoveryCodes returns the codes to show the user and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes = append(codes, code)
+		hashes = append(hashes, helpers.HashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// useTwoFactorCode accepts a totp code or a recovery code for a user with 2fa
+// on. A totp code is only accepted for a step after the last one used and a
+// recovery code is removed as it is used - both happen in the update filter so
+// two requests racing with the same code cannot both win
+func useTwoFactorCode(user *models.User, code string) (bool, error) {
+	if step, ok := totp.Validate(user.TOTP.Secret, code, time.Now()); ok {
+		filter := bson.M{"_id": user.ID, "totp.enabled": true, "$or": bson.A{
+			bson.M{"totp.lastStep": bson.M{"$lt": step}},
+			bson.M{"totp.lastStep": bson.M{"$exists": false}},
+		}}
+		update := bson.M{"$set": bson.M{"totp.lastStep": step}}
+		result, err := usersCollection.UpdateOne(context.TODO(), filter, update)
+		if err != nil {
+			return false, err
+		}
+		return result.MatchedCount == 1, nil
+	}
+	hash := helpers.HashToken(normalizeRecoveryCode(code))
+	filter := bson.M{"_id": user.ID, "totp.enabled": true, "totp.recoveryCodes": hash}
+	update := bson.M{"$pull": bson.M{"totp.recoveryCodes": hash}}
+	result, err := usersCollection.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// CompleteTwoFactorLogin is the second step of a login with 2fa on - a valid
+// challenge token and code start the session. Codes are counted per user with
+// the backoff policy of the account, apart from its password failures
+func CompleteTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.TwoFactorLoginRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	uId, cErr := parseChallengeToken(req.ChallengeToken)
+	if cErr != nil {
+		er.Respond(w, 401, "error", errInvalidChallenge.Error())
+		return
+	}
+	// the code counts as wrong until it checks out, so parallel guesses each
+	// take an attempt
+	if wait, _, _ := accountLimiter.Attempt(context.TODO(), totpKey(uId)); wait > 0 {
+		secs := setRetryAfter(w, wait)
+		er.Respond(w, 429, "error", retryMessage("too many failed codes", secs))
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": uId}).Decode(&user)
+	if err != nil || !user.TwoFactorEnabled() {
+		er.Respond(w, 401, "error", errInvalidChallenge.Error())
+		return
+	}
+	ok, uErr := useTwoFactorCode(&user, req.Code)
+	if uErr != nil {
+		er.Respond(w, 500, "error", uErr.Error())
+		return
+	}
+	if !ok {
+		auditLogin(r, user.Email, &user.ID, models.LoginBadTwoFactor)
+		er.Respond(w, 401, "error", "invalid code")
+		return
+	}
+	accountLimiter.Reset(context.TODO(), totpKey(uId))
+	auditLogin(r, user.Email, &user.ID, models.LoginSuccess)
+	respondNewSession(w, r, &user)
+}
+
+// EnrollTwoFactor starts 2fa setup for the auth user - the secret is pending
+// until a code from it is confirmed
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if user.TwoFactorEnabled() {
+		er.Respond(w, 400, "error", "two factor authentication is already on")
+		return
+	}
+	secret, sErr := totp.GenerateSecret()
+	if sErr != nil {
+		er.Respond(w, 500, "error", sErr.Error())
+		return
+	}
+	update := bson.M{"$set": bson.M{"totp.pendingSecret": secret}}
+	if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.TwoFactorEnrollResponse{
+		Secret:     secret,
+		OtpauthURI: totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor turns 2fa on once a code from the pending secret is given
+// and responds with the recovery codes - the only time they are shown
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.TwoFactorCodeRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if user.TwoFactorEnabled() || user.TOTP == nil || user.TOTP.PendingSecret == "" {
+		er.Respond(w, 400, "error", "no two factor enrollment is pending")
+		return
+	}
+	step, ok := totp.Validate(user.TOTP.PendingSecret, req.Code, time.Now())
+	if !ok {
+		er.Respond(w, 400, "error", "invalid code")
+		return
+	}
+	codes, hashes, cErr := newRecoveryCodes()
+	if cErr != nil {
+		er.Respond(w, 500, "error", cErr.Error())
+		return
+	}
+	// the pending secret in the filter stops a concurrent re-enroll from
+	// being confirmed with a code for the old secret
+	filter := bson.M{"_id": user.ID, "totp.pendingSecret": user.TOTP.PendingSecret}
+	update := bson.M{"$set": bson.M{
+		"totp": models.UserTOTP{
+			Enabled:       true,
+			Secret:        user.TOTP.PendingSecret,
+			RecoveryCodes: hashes,
+			LastStep:      step,
+			EnabledAt:     primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+	}}
+	result, uErr := usersCollection.UpdateOne(context.TODO(), filter, update)
+	if uErr != nil {
+		er.Respond(w, 500, "error", uErr.Error())
+		return
+	}
+	if result.MatchedCount == 0 {
+		er.Respond(w, 400, "error", "no two factor enrollment is pending")
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the auth user - a
+// current code is needed and the old recovery codes stop working
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.TwoFactorCodeRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		er.Respond(w, 400, "error", "two factor authentication is off")
+		return
+	}
+	ok, uErr := useTwoFactorCode(&user, req.Code)
+	if uErr != nil {
+		er.Respond(w, 500, "error", uErr.Error())
+		return
+	}
+	if !ok {
+		er.Respond(w, 401, "error", "invalid code")
+		return
+	}
+	codes, hashes, cErr := newRecoveryCodes()
+	if cErr != nil {
+		er.Respond(w, 500, "error", cErr.Error())
+		return
+	}
+	update := bson.M{"$set": bson.M{"totp.recoveryCodes": hashes}}
+	if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", responses.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns 2fa off for the auth user - both the password and a
+// current code or recovery code are needed, or only the code for users
+// without a password
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.DisableTwoFactorRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if !user.TwoFactorEnabled() {
+		er.Respond(w, 400, "error", "two factor authentication is off")
+		return
+	}
+	if user.HasPassword() && !verifyPasswordHash(req.Password, user.Password) {
+		er.Respond(w, 401, "error", "unauthorized")
+		return
+	}
+	ok, uErr := useTwoFactorCode(&user, req.Code)
+	if uErr != nil {
+		er.Respond(w, 500, "error", uErr.Error())
+		return
+	}
+	if !ok {
+		er.Respond(w, 401, "error", "invalid code")
+		return
+	}
+	update := bson.M{
+		"$unset": bson.M{"totp": ""},
+		"$set":   bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	if _, err := usersCollection.UpdateOne(context.TODO(), bson.M{"_id": user.ID}, update); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "two factor authentication turned off")
+}
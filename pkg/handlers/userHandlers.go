@@ -1,20 +1,28 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/export"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
 	"github.com/golang-jwt/jwt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,26 +35,51 @@ var usersCollection = db.GetCollection(
 var jwtSec = os.Getenv("JWT_SECRET")
 
 type JWTCustomClaims struct {
-	UserId string
+	UserId    string
+	SessionId string `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
-func GenerateAuthToken(userId string) (string, error) {
+// GenerateAuthToken issues a short lived access token for a session - the
+// expiry is returned alongside so clients know when to refresh
+func GenerateAuthToken(userId, sessionId string) (string, time.Time, error) {
 	jwtSecret := []byte(jwtSec)
 	t := time.Now()
+	exp := t.Add(accessTokenTTL)
 	claims := JWTCustomClaims{
 		userId,
+		sessionId,
 		jwt.StandardClaims{
-			Issuer:   "helloBourbon",
-			IssuedAt: t.Unix(),
+			Issuer:    "helloBourbon",
+			IssuedAt:  t.Unix(),
+			ExpiresAt: exp.Unix(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtSecret)
 	if err != nil {
-		return "", err
+		return "", time.Time{}, err
 	}
-	return tokenString, err
+	return tokenString, exp, err
+}
+
+// caseInsensitive compares usernames and emails without case - it also
+// matches emails stored before they were lowercased on registration
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// UsernameTaken reports whether a user other than exclude has the username in
+// any case
+func UsernameTaken(ctx context.Context, username string, exclude primitive.ObjectID) (bool, error) {
+	filter := bson.M{"username": username, "_id": bson.M{"$ne": exclude}}
+	count, err := usersCollection.CountDocuments(ctx, filter, options.Count().SetCollation(caseInsensitive))
+	return count > 0, err
+}
+
+// EmailTaken reports whether a user other than exclude has the email in any case
+func EmailTaken(ctx context.Context, email string, exclude primitive.ObjectID) (bool, error) {
+	filter := bson.M{"email": email, "_id": bson.M{"$ne": exclude}}
+	count, err := usersCollection.CountDocuments(ctx, filter, options.Count().SetCollation(caseInsensitive))
+	return count > 0, err
 }
 
 func verifyPasswordHash(password, hash string) bool {
@@ -54,43 +87,65 @@ func verifyPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
+// missingUserHash is compared against when no user has the email - it is
+// made with the cost of real password hashes
+var missingUserHash, _ = helpers.HashPassword("no user has this email")
+
 func findByCredentials(email, password string) (
 	*models.
 		User, error,
 ) {
 	filter := bson.M{"email": email}
-	var result bson.M
 	var user models.User
-	err := usersCollection.FindOne(context.TODO(), filter).Decode(&result)
+	opts := options.FindOne().SetCollation(caseInsensitive)
+	err := usersCollection.FindOne(context.TODO(), filter, opts).Decode(&user)
 	if err != nil {
+		// compare anyway so a miss takes as long as a wrong password
+		verifyPasswordHash(password, missingUserHash)
 		return &models.User{}, err
 	}
-	requestPass := fmt.Sprint(result["password"])
-	if verifyPasswordHash(password, requestPass) {
-		byteResult, _ := json.Marshal(result)
-		err := json.Unmarshal(byteResult, &user)
-		if err != nil {
-			return &models.User{}, err
-		}
+	if verifyPasswordHash(password, user.Password) {
 		return &user, nil
 	} else {
+		// the id is kept so the failure can be audited against the user
 		err = errors.New("unauthorized")
-		return &models.User{}, err
+		return &models.User{ID: user.ID}, err
 	}
 }
 
 func CreateUser(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
 	newUser := r.Context().Value("user").(*models.User)
-	tokenFromCtx := newUser.Tokens[0].Token
+	session, refreshToken, sErr := newSession(r)
+	if sErr != nil {
+		er.Respond(w, 500, "error", sErr.Error())
+		return
+	}
+	token, exp, tErr := GenerateAuthToken(newUser.ID.Hex(), session.SessionID)
+	if tErr != nil {
+		er.Respond(w, 500, "error", tErr.Error())
+		return
+	}
+	newUser.Tokens = append(newUser.Tokens, session)
 	_, err := usersCollection.InsertOne(context.TODO(), newUser)
+	if fErrs := duplicateUserFields(err); fErrs != nil {
+		er.Respond(w, 400, "error", fErrs)
+		return
+	}
 	if err != nil {
-		var er responses.ErrorResponse
 		er.Respond(w, 500, "error", err.Error())
 		return
 	}
+	// a failed mail should not fail the registration - the user can ask again
+	if mErr := sendVerificationEmail(newUser); mErr != nil {
+		log.Printf("verification mail to %s failed: %v", newUser.Email, mErr)
+	}
 	ur := responses.UserTokenResponse{
-		User:  newUser,
-		Token: tokenFromCtx,
+		User:         newUser,
+		Token:        token,
+		ExpiresAt:    primitive.NewDateTimeFromTime(exp),
+		RefreshToken: refreshToken,
+		SessionID:    session.SessionID,
 	}
 	var sr responses.StandardResponse
 	sr.Respond(w, 200, "success", ur)
@@ -111,32 +166,67 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 		er.Respond(w, 500, "error", missing.Error())
 		return
 	}
+	// counters and the audit trail key on the normalized email whether or not
+	// it belongs to a user so accounts cannot be probed
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	ip := helpers.ClientIP(r)
+	// a throttled attempt is refused before bcrypt runs - any other counts as
+	// failed until the password checks out
+	if wait, locked := loginAttempt(email, ip); wait > 0 {
+		secs := setRetryAfter(w, wait)
+		if locked {
+			auditLogin(r, email, nil, models.LoginLocked)
+			er.Respond(w, 429, "error", retryMessage("too many failed logins, login is locked", secs))
+			return
+		}
+		auditLogin(r, email, nil, models.LoginThrottled)
+		er.Respond(w, 429, "error", retryMessage("too many failed logins", secs))
+		return
+	}
 	verifiedUser, vError := findByCredentials(
-		req.Email,
+		email,
 		req.Password,
 	)
 	if vError != nil {
-		er.Respond(w, 401, "error", vError.Error())
+		var uId *primitive.ObjectID
+		if !verifiedUser.ID.IsZero() {
+			uId = &verifiedUser.ID
+		}
+		auditLogin(r, email, uId, models.LoginBadCredentials)
+		er.Respond(w, 401, "error", "unauthorized")
+		return
+	}
+	loginSucceeded(email, ip)
+	// with 2fa on the password only earns a challenge token for the second step
+	if verifiedUser.TwoFactorEnabled() {
+		auditLogin(r, email, &verifiedUser.ID, models.LoginTwoFactorRequired)
+		respondTwoFactorChallenge(w, verifiedUser.ID)
+		return
+	}
+	auditLogin(r, email, &verifiedUser.ID, models.LoginSuccess)
+	respondNewSession(w, r, verifiedUser)
+}
+
+// respondNewSession starts a session for a user that has proven who they are
+// and responds with the user and the tokens of the session
+func respondNewSession(w http.ResponseWriter, r *http.Request, verifiedUser *models.User) {
+	var er responses.ErrorResponse
+	session, refreshToken, sErr := newSession(r)
+	if sErr != nil {
+		er.Respond(w, 500, "error", sErr.Error())
 		return
 	}
-	token, tErr := GenerateAuthToken(verifiedUser.ID.Hex())
+	token, exp, tErr := GenerateAuthToken(verifiedUser.ID.Hex(), session.SessionID)
 	if tErr != nil {
 		er.Respond(w, 500, "error", tErr.Error())
 		return
 	}
-	dbToken := models.UserTokenRef{
-		Token: token,
-	}
-	filter := bson.M{"_id": verifiedUser.ID}
-	tokenUpdate := bson.M{"$push": bson.M{"tokens": dbToken}}
-	_, uErr := usersCollection.UpdateOne(
-		context.TODO(), filter,
-		tokenUpdate,
-	)
+	uErr := storeSession(verifiedUser.ID, session)
 	if uErr != nil {
 		er.Respond(w, 500, "error", uErr.Error())
 		return
 	}
+	filter := bson.M{"_id": verifiedUser.ID}
 	updatedTime := primitive.NewDateTimeFromTime(time.Now())
 	tUpdate := bson.M{"$set": bson.M{"updatedAt": updatedTime}}
 	_, tUErr := usersCollection.UpdateOne(context.TODO(), filter, tUpdate)
@@ -145,8 +235,11 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	ur := responses.UserTokenResponse{
-		User:  verifiedUser,
-		Token: token,
+		User:         verifiedUser,
+		Token:        token,
+		ExpiresAt:    primitive.NewDateTimeFromTime(exp),
+		RefreshToken: refreshToken,
+		SessionID:    session.SessionID,
 	}
 	var sr responses.StandardResponse
 	sr.Respond(w, 200, "success", ur)
@@ -157,9 +250,8 @@ func LogoutUser(w http.ResponseWriter, r *http.Request) {
 	var sr responses.StandardResponse
 	ctx := r.Context().Value("authContext").(*models.AuthContext)
 	id := ctx.UserId
-	t := ctx.Token
-	filter := bson.D{{"_id", id}, {"tokens.token", t}}
-	update := bson.M{"$pull": bson.M{"tokens": bson.D{{"token", t}}}}
+	filter := bson.D{{Key: "_id", Value: id}, {Key: "tokens.sessionId", Value: ctx.SessionID}}
+	update := bson.M{"$pull": bson.M{"tokens": bson.M{"sessionId": ctx.SessionID}}}
 	result, err := usersCollection.UpdateOne(context.TODO(), filter, update)
 	if err != nil {
 		er.Respond(w, 400, "error", err.Error())
@@ -179,3 +271,123 @@ func LogoutUser(w http.ResponseWriter, r *http.Request) {
 	}
 	sr.Respond(w, 200, "logged out", "logout successful")
 }
+
+// ExportUserData returns a zip archive of the profile, owned collections and
+// wishlists and reviews of the auth user as json and csv
+func ExportUserData(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	archive := export.Archive{User: &user, CreatedAt: time.Now()}
+	filter := bson.M{"user.id": ctx.UserId}
+	if err := findAll(collectionsCollection, filter, &archive.Collections); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if err := findAll(wishlistsCollection, filter, &archive.Wishlists); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	if err := findAll(reviewsCollection, filter, &archive.Reviews); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	// build the archive in memory so a failure can still be reported as json
+	var buf bytes.Buffer
+	if err := archive.Write(&buf); err != nil {
+		er.Respond(w, 500, "error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", archive.FileName()))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
+
+// findAll decodes every document matching the filter into results
+func findAll(coll *mongo.Collection, filter bson.M, results interface{}) error {
+	cursor, err := coll.Find(context.TODO(), filter)
+	if err != nil {
+		return err
+	}
+	return cursor.All(context.TODO(), results)
+}
+
+// DeleteUser closes the account of the auth user once they reauthenticate.
+// Owned collections and wishlists are deleted, memberships in the lists of
+// others are removed and reviews are either deleted or left behind with a
+// tombstone user ref
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	var er responses.ErrorResponse
+	ctx := r.Context().Value("authContext").(*models.AuthContext)
+	rBody, _ := ioutil.ReadAll(r.Body)
+	var req models.DeleteAccountRequest
+	if err := json.Unmarshal(rBody, &req); err != nil {
+		er.Respond(w, 400, "error", err.Error())
+		return
+	}
+	var user models.User
+	err := usersCollection.FindOne(context.TODO(), bson.M{"_id": ctx.UserId}).Decode(&user)
+	if err != nil {
+		er.Respond(w, 404, "error", "user not found")
+		return
+	}
+	if user.HasPassword() && req.Password == "" {
+		er.Respond(w, 400, "error", "password is required")
+		return
+	}
+	if !respondReauthenticate(w, &user, ctx.SessionID, req.Password, req.Code) {
+		return
+	}
+	var definedError responses.ErrorResponse
+	runControllerTransaction(&definedError, func(sc mongo.SessionContext) error {
+		owned := bson.M{"user.id": user.ID}
+		if req.DeleteReviews {
+			if _, err := reviewsCollection.DeleteMany(sc, owned); err != nil {
+				return err
+			}
+		} else {
+			update := bson.M{"$set": bson.M{
+				"user":      models.TombstoneUserRef(),
+				"updatedAt": primitive.NewDateTimeFromTime(time.Now()),
+			}}
+			if _, err := reviewsCollection.UpdateMany(sc, owned, update); err != nil {
+				return err
+			}
+		}
+		membership := bson.M{"members.user.id": user.ID}
+		leave := bson.M{
+			"$pull": bson.M{"members": bson.M{"user.id": user.ID}},
+			"$set":  bson.M{"updatedAt": primitive.NewDateTimeFromTime(time.Now())},
+		}
+		for _, coll := range []*mongo.Collection{collectionsCollection, wishlistsCollection} {
+			if _, err := coll.DeleteMany(sc, owned); err != nil {
+				return err
+			}
+			if _, err := coll.UpdateMany(sc, membership, leave); err != nil {
+				return err
+			}
+		}
+		result, err := usersCollection.DeleteOne(sc, bson.M{"_id": user.ID})
+		if err != nil {
+			return err
+		}
+		if result.DeletedCount == 0 {
+			definedError.Build(404, "error", "user not found")
+			return errTxAborted
+		}
+		return nil
+	})
+	if definedError.Status != 0 {
+		definedError.Respond(w, definedError.Status, definedError.Message, definedError.Data["data"])
+		return
+	}
+	var sr responses.StandardResponse
+	sr.Respond(w, 200, "success", "account deleted")
+}
@@ -1,9 +1,19 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func GetGoDotEnv(key string) string {
@@ -13,3 +23,118 @@ func GetGoDotEnv(key string) string {
 	}
 	return os.Getenv(key)
 }
+
+// GenerateRandomToken returns a url safe string built from n bytes of
+// crypto/rand output - used anywhere we need an unguessable value
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashPassword bcrypts a password for storage on a user
+func HashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	return string(bytes), err
+}
+
+// HashToken returns the hex sha256 of a secret - refresh tokens and other
+// bearer secrets are only ever stored hashed
+func HashToken(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnvDuration reads a duration such as 15m from the environment, falling
+// back to def when the variable is unset or bad
+func EnvDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
+
+// EnvInt reads a positive int from the environment, falling back to def when
+// the variable is unset or bad
+func EnvInt(key string, def int) int {
+	i, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || i <= 0 {
+		return def
+	}
+	return i
+}
+
+// trustedProxies are the proxies allowed to set X-Forwarded-For, read from
+// TRUSTED_PROXIES as a comma separated list of ips and cidrs
+var trustedProxies = ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+
+// ParseProxies reads a comma separated list of ips and cidrs - entries that
+// are neither are skipped
+func ParseProxies(list string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		// a single ip is a cidr of one address
+		if ip := net.ParseIP(p); ip != nil {
+			if ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		if _, n, err := net.ParseCIDR(p); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+func trusted(proxies []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client making the request
+func ClientIP(r *http.Request) string {
+	return clientIP(r, trustedProxies)
+}
+
+// clientIP only reads X-Forwarded-For when the request came from a trusted
+// proxy, as anyone can send the header. Each proxy appends the address it was
+// reached from, so the client is the last entry not added by a trusted proxy
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(proxies, host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !trusted(proxies, hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
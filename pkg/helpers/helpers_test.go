@@ -0,0 +1,35 @@
+package helpers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies := ParseProxies("10.0.0.0/8, 192.168.1.1, bad, ::1")
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"no proxy", "203.0.113.7:5123", nil, "203.0.113.7"},
+		{"untrusted peer sending xff", "203.0.113.7:5123", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:443", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry before the proxy", "10.1.2.3:443", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.1.2.3:443", []string{"198.51.100.1, 192.168.1.1"}, "198.51.100.1"},
+		{"headers split over lines", "10.1.2.3:443", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies", "10.1.2.3:443", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"trusted proxy without xff", "10.1.2.3:443", nil, "10.1.2.3"},
+		{"ipv6 proxy", "[::1]:443", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		r := &http.Request{RemoteAddr: tt.remote, Header: http.Header{}}
+		for _, v := range tt.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := clientIP(r, proxies); got != tt.want {
+			t.Errorf("%s: clientIP = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+// Package importer parses a users bottle list from a csv export and fuzzy
+// matches every row against the bourbon catalog
+package importer
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxRows bounds a single import
+const MaxRows = 1000
+
+// MatchThreshold is the confidence a best match needs before a row counts as matched
+const MatchThreshold = 0.6
+
+// Row is one bottle read from the csv - Line is the 1 based line in the file
+type Row struct {
+	Line      int        `json:"line"`
+	Name      string     `json:"name"`
+	PricePaid *float64   `json:"price_paid,omitempty"`
+	Date      *time.Time `json:"date,omitempty"`
+	Warnings  []string   `json:"warnings,omitempty"`
+}
+
+// Candidate is a catalog bourbon a row might be
+type Candidate struct {
+	BourbonID  string  `json:"bourbon_id"`
+	Title      string  `json:"title"`
+	Distiller  string  `json:"distiller"`
+	Bottler    string  `json:"bottler"`
+	Confidence float64 `json:"confidence"`
+}
+
+// header aliases used by spreadsheets and other tracking apps
+var (
+	nameHeaders  = []string{"name", "bottle", "bottle name", "title", "bourbon", "whiskey", "product"}
+	priceHeaders = []string{"price", "price paid", "price_paid", "paid", "cost", "msrp"}
+	dateHeaders  = []string{"date", "purchased", "purchase date", "purchase_date", "date purchased", "acquired", "bought"}
+	dateLayouts  = []string{"2006-01-02", "01/02/2006", "1/2/2006", "01/02/06", "1/2/06", "2006/01/02", "Jan 2 2006", "Jan 2, 2006", "January 2, 2006", "2 Jan 2006", time.RFC3339}
+)
+
+func headerIndex(header []string, aliases []string) int {
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		for _, a := range aliases {
+			if h == a {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ParseCSV reads bottle rows from a csv - a header row is used when it names a
+// bottle column, otherwise the columns are read as name, price, date
+func ParseCSV(r io.Reader) ([]*Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("csv is empty")
+	}
+	nameCol, priceCol, dateCol := 0, 1, 2
+	start := 0
+	if idx := headerIndex(records[0], nameHeaders); idx >= 0 {
+		nameCol = idx
+		priceCol = headerIndex(records[0], priceHeaders)
+		dateCol = headerIndex(records[0], dateHeaders)
+		start = 1
+	}
+	if len(records)-start > MaxRows {
+		return nil, fmt.Errorf("an import can have at most %d rows", MaxRows)
+	}
+	rows := make([]*Row, 0, len(records)-start)
+	for i := start; i < len(records); i++ {
+		rec := records[i]
+		row := &Row{Line: i + 1}
+		if nameCol < len(rec) {
+			row.Name = strings.TrimSpace(rec[nameCol])
+		}
+		if row.Name == "" {
+			continue
+		}
+		if priceCol >= 0 && priceCol < len(rec) && strings.TrimSpace(rec[priceCol]) != "" {
+			p, pErr := parsePrice(rec[priceCol])
+			if pErr != nil {
+				row.Warnings = append(row.Warnings, "price could not be read")
+			} else {
+				row.PricePaid = &p
+			}
+		}
+		if dateCol >= 0 && dateCol < len(rec) && strings.TrimSpace(rec[dateCol]) != "" {
+			d, dErr := ParseDate(rec[dateCol])
+			if dErr != nil {
+				row.Warnings = append(row.Warnings, "date could not be read")
+			} else {
+				row.Date = &d
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parsePrice(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimLeft(s, "$€£")
+	s = strings.ReplaceAll(s, ",", "")
+	p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || p < 0 {
+		return 0, errors.New("bad price")
+	}
+	return p, nil
+}
+
+// ParseDate reads a date in any of the layouts found in csv exports - the
+// rfc3339 dates of a preview read back too
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("bad date")
+}
+
+// words that show up in bottle names without telling bottles apart
+var stopWords = map[string]bool{
+	"bourbon": true, "whiskey": true, "whisky": true, "kentucky": true,
+	"straight": true, "the": true, "and": true, "of": true,
+}
+
+// normalize lowercases, strips punctuation and drops stop words
+func normalize(s string) []string {
+	s = strings.ToLower(s)
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return ' '
+	}, s)
+	tokens := make([]string, 0)
+	for _, t := range strings.Fields(s) {
+		if !stopWords[t] {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// tokenDice is the dice coefficient of two token sets
+func tokenDice(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(b))
+	for _, t := range b {
+		set[t] = true
+	}
+	shared := 0
+	seen := make(map[string]bool, len(a))
+	for _, t := range a {
+		if set[t] && !seen[t] {
+			shared++
+		}
+		seen[t] = true
+	}
+	return 2 * float64(shared) / float64(len(seen)+len(set))
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
+
+// similarity blends token overlap with edit distance so both reordered words
+// and typos score well - the result is between 0 and 1
+func similarity(a, b []string) float64 {
+	as, bs := strings.Join(a, " "), strings.Join(b, " ")
+	if as == "" || bs == "" {
+		return 0
+	}
+	if as == bs {
+		return 1
+	}
+	ar, br := []rune(as), []rune(bs)
+	longest := len(ar)
+	if len(br) > longest {
+		longest = len(br)
+	}
+	edit := 1 - float64(levenshtein(ar, br))/float64(longest)
+	return 0.6*tokenDice(a, b) + 0.4*edit
+}
+
+// Matcher scores names against a catalog - build it once per import. A name
+// is only scored against the entries it shares a word with, so a preview costs
+// a few edit distances per row rather than one per catalog entry
+type Matcher struct {
+	entries []matchEntry
+	// byToken lists the entries with the token in any variant, in catalog
+	// order
+	byToken map[string][]int
+}
+
+type matchEntry struct {
+	bourbon  models.Bourbon
+	variants [][]string
+}
+
+func NewMatcher(catalog []models.Bourbon) *Matcher {
+	m := &Matcher{entries: make([]matchEntry, 0, len(catalog)), byToken: make(map[string][]int)}
+	for i, b := range catalog {
+		title := normalize(b.Title)
+		variants := [][]string{title}
+		// people often write the distiller or bottler in front of the title
+		if b.Distiller != "" {
+			variants = append(variants, append(normalize(b.Distiller), title...))
+		}
+		if b.Bottler != "" && b.Bottler != b.Distiller {
+			variants = append(variants, append(normalize(b.Bottler), title...))
+		}
+		m.entries = append(m.entries, matchEntry{bourbon: b, variants: variants})
+		for _, v := range variants {
+			for _, t := range v {
+				if ids := m.byToken[t]; len(ids) == 0 || ids[len(ids)-1] != i {
+					m.byToken[t] = append(ids, i)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Match returns up to limit candidates for a name, best first
+func (m *Matcher) Match(name string, limit int) []*Candidate {
+	tokens := normalize(name)
+	shared := make(map[int]bool)
+	for _, t := range tokens {
+		for _, i := range m.byToken[t] {
+			shared[i] = true
+		}
+	}
+	ids := make([]int, 0, len(shared))
+	for i := range shared {
+		ids = append(ids, i)
+	}
+	// catalog order keeps ties in the same order from one preview to the next
+	sort.Ints(ids)
+	candidates := make([]*Candidate, 0, len(ids))
+	for _, i := range ids {
+		e := m.entries[i]
+		best := 0.0
+		for _, v := range e.variants {
+			if s := similarity(tokens, v); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			continue
+		}
+		candidates = append(candidates, &Candidate{
+			BourbonID:  e.bourbon.ID.Hex(),
+			Title:      e.bourbon.Title,
+			Distiller:  e.bourbon.Distiller,
+			Bottler:    e.bourbon.Bottler,
+			Confidence: float64(int(best*1000)) / 1000,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
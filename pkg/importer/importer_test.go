@@ -0,0 +1,114 @@
+package importer
+
+import (
+	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"2024-03-01", true},
+		{"03/01/2024", true},
+		{"3/1/2024", true},
+		{"03/01/24", true},
+		{"2024/03/01", true},
+		{"Mar 1 2024", true},
+		{"Mar 1, 2024", true},
+		{"March 1, 2024", true},
+		{"1 Mar 2024", true},
+		{"2024-03-01T00:00:00Z", true},
+		{" 2024-03-01 ", true},
+		{"yesterday", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, err := ParseDate(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseDate(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if tt.ok && !got.Equal(want) {
+			t.Errorf("ParseDate(%q) = %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+// the rows of a preview sent back to confirm as they were served must keep
+// their dates
+func TestPreviewDatesRoundTrip(t *testing.T) {
+	rows, err := ParseCSV(strings.NewReader("name,price,date\nBlanton's,65,03/01/2024\nWeller 12,$40,Jan 2 2023\nEagle Rare,,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var confirm []*models.ImportConfirmRow
+	if err := json.Unmarshal(b, &confirm); err != nil {
+		t.Fatal(err)
+	}
+	if len(confirm) != len(rows) {
+		t.Fatalf("got %d confirm rows, want %d", len(confirm), len(rows))
+	}
+	for i, row := range rows {
+		if row.Date == nil {
+			if confirm[i].Date != "" {
+				t.Errorf("row %d date = %q, want none", i, confirm[i].Date)
+			}
+			continue
+		}
+		got, err := ParseDate(confirm[i].Date)
+		if err != nil {
+			t.Errorf("row %d date %q does not read back: %v", i, confirm[i].Date, err)
+			continue
+		}
+		if !got.Equal(*row.Date) {
+			t.Errorf("row %d date = %v, want %v", i, got, *row.Date)
+		}
+	}
+}
+
+func TestMatchSharesAToken(t *testing.T) {
+	m := NewMatcher([]models.Bourbon{
+		{ID: primitive.NewObjectID(), Title: "Blanton's Original Single Barrel", Distiller: "Buffalo Trace"},
+		{ID: primitive.NewObjectID(), Title: "Eagle Rare 10 Year"},
+		{ID: primitive.NewObjectID(), Title: "Weller Special Reserve"},
+	})
+	tests := []struct {
+		name string
+		want []string
+	}{
+		// a typo is still scored as long as another word matches
+		{"Eagle Rair 10", []string{"Eagle Rare 10 Year"}},
+		{"buffalo trace blantons", []string{"Blanton's Original Single Barrel"}},
+		{"Weller Single Barrel", []string{"Blanton's Original Single Barrel", "Weller Special Reserve"}},
+		{"Pappy Van Winkle", nil},
+		{"Kentucky Straight Bourbon", nil},
+	}
+	for _, tt := range tests {
+		got := m.Match(tt.name, 5)
+		titles := make([]string, 0, len(got))
+		for _, c := range got {
+			titles = append(titles, c.Title)
+		}
+		if len(titles) != len(tt.want) {
+			t.Errorf("Match(%q) = %q, want %q", tt.name, titles, tt.want)
+			continue
+		}
+		for i := range titles {
+			if titles[i] != tt.want[i] {
+				t.Errorf("Match(%q) = %q, want %q", tt.name, titles, tt.want)
+				break
+			}
+		}
+	}
+}
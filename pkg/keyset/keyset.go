@@ -0,0 +1,95 @@
+// Package keyset pages through a sorted read by position instead of by skip.
+// A cursor holds the sort key and _id of a document, with _id breaking ties
+// between equal keys, and reads on from there in either direction
+package keyset
+
+import (
+	"encoding/base64"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+)
+
+// Cursor is the position of a document in a read sorted by Sort in Dir.
+// Before reads the page that comes ahead of the document instead of after it
+type Cursor struct {
+	Sort   string             `bson:"s"`
+	Dir    int                `bson:"d"`
+	Key    bson.RawValue      `bson:"k"`
+	ID     primitive.ObjectID `bson:"id"`
+	Before bool               `bson:"b,omitempty"`
+}
+
+var ErrInvalid = errors.New("cursor is invalid")
+
+// At is the cursor of a document as it was read - a missing sort key is kept
+// as null, which is where mongo sorts it
+func At(raw bson.Raw, sort string, dir int, before bool) *Cursor {
+	key, err := raw.LookupErr(strings.Split(sort, ".")...)
+	if err != nil || key.Type == bsontype.Undefined {
+		key = bson.RawValue{Type: bsontype.Null}
+	}
+	id, _ := raw.Lookup("_id").ObjectIDOK()
+	return &Cursor{Sort: sort, Dir: dir, Key: key, ID: id, Before: before}
+}
+
+// Encode makes the cursor opaque to clients - canonical extended json keeps
+// the bson type of the key through the round trip
+func (c *Cursor) Encode() string {
+	b, err := bson.MarshalExtJSON(c, true, false)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func Decode(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var c Cursor
+	if err := bson.UnmarshalExtJSON(b, true, &c); err != nil {
+		return nil, ErrInvalid
+	}
+	if c.ID.IsZero() || (c.Dir != 1 && c.Dir != -1) || c.Key.Type == 0 {
+		return nil, ErrInvalid
+	}
+	return &c, nil
+}
+
+// ReadDir is the direction the read walks the sort in
+func (c *Cursor) ReadDir() int {
+	if c.Before {
+		return -c.Dir
+	}
+	return c.Dir
+}
+
+// Filter matches the documents past the cursor in the direction of the read.
+// Comparisons only match keys of the same type so null keys, which sort
+// lowest, are matched apart
+func (c *Cursor) Filter() bson.M {
+	op := "$gt"
+	if c.ReadDir() < 0 {
+		op = "$lt"
+	}
+	var or []bson.M
+	if c.Key.Type == bsontype.Null {
+		or = append(or, bson.M{c.Sort: nil, "_id": bson.M{op: c.ID}})
+		if c.ReadDir() > 0 {
+			or = append(or, bson.M{c.Sort: bson.M{"$ne": nil}})
+		}
+	} else {
+		or = append(or,
+			bson.M{c.Sort: bson.M{op: c.Key}},
+			bson.M{c.Sort: c.Key, "_id": bson.M{op: c.ID}},
+		)
+		if c.ReadDir() < 0 {
+			or = append(or, bson.M{c.Sort: nil})
+		}
+	}
+	return bson.M{"$or": or}
+}
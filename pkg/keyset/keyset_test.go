@@ -0,0 +1,165 @@
+package keyset
+
+import (
+	"encoding/base64"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func rawDoc(t *testing.T, doc bson.M) bson.Raw {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bson.Raw(b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name string
+		doc  bson.M
+		sort string
+		want bsontype.Type
+	}{
+		{"string key", bson.M{"_id": id, "title": "Blanton's"}, "title", bsontype.String},
+		{"double key", bson.M{"_id": id, "abv": 46.5}, "abv", bsontype.Double},
+		{"int key", bson.M{"_id": id, "age": int32(12)}, "age", bsontype.Int32},
+		{"date key", bson.M{"_id": id, "added": primitive.NewDateTimeFromTime(time.Unix(1700000000, 0))}, "added", bsontype.DateTime},
+		{"nested key", bson.M{"_id": id, "price": bson.M{"msrp": 59.99}}, "price.msrp", bsontype.Double},
+		{"missing key is null", bson.M{"_id": id}, "title", bsontype.Null},
+		{"null key", bson.M{"_id": id, "title": nil}, "title", bsontype.Null},
+	}
+	for _, tt := range tests {
+		for _, before := range []bool{false, true} {
+			c := At(rawDoc(t, tt.doc), tt.sort, -1, before)
+			got, err := Decode(c.Encode())
+			if err != nil {
+				t.Errorf("%s: Decode: %v", tt.name, err)
+				continue
+			}
+			if got.Key.Type != tt.want {
+				t.Errorf("%s: key type %v, want %v", tt.name, got.Key.Type, tt.want)
+			}
+			if !got.Key.Equal(c.Key) || got.ID != id || got.Sort != tt.sort || got.Dir != -1 || got.Before != before {
+				t.Errorf("%s: round trip = %+v, want %+v", tt.name, got, c)
+			}
+		}
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	encode := func(doc bson.M) string {
+		b, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	id := primitive.NewObjectID()
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"not base64", "not a cursor!"},
+		{"not json", base64.RawURLEncoding.EncodeToString([]byte("{nope"))},
+		{"no id", encode(bson.M{"s": "title", "d": 1, "k": "a"})},
+		{"bad direction", encode(bson.M{"s": "title", "d": 2, "k": "a", "id": id})},
+		{"no key", encode(bson.M{"s": "title", "d": 1, "id": id})},
+	}
+	for _, tt := range tests {
+		if c, err := Decode(tt.in); err != ErrInvalid {
+			t.Errorf("%s: Decode = %+v, %v, want ErrInvalid", tt.name, c, err)
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	tests := []struct {
+		dir    int
+		before bool
+		want   int
+	}{
+		{1, false, 1},
+		{1, true, -1},
+		{-1, false, -1},
+		{-1, true, 1},
+	}
+	for _, tt := range tests {
+		c := &Cursor{Dir: tt.dir, Before: tt.before}
+		if got := c.ReadDir(); got != tt.want {
+			t.Errorf("ReadDir(dir %d, before %v) = %d, want %d", tt.dir, tt.before, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	null := bson.RawValue{Type: bsontype.Null}
+	_, v, err := bson.MarshalValue("Blanton's")
+	if err != nil {
+		t.Fatal(err)
+	}
+	title := bson.RawValue{Type: bsontype.String, Value: v}
+	tests := []struct {
+		name   string
+		key    bson.RawValue
+		dir    int
+		before bool
+		want   []bson.M
+	}{
+		{
+			// nulls sort first, so ascending past a null reads the later
+			// nulls and then every key that is set
+			"null key ascending", null, 1, false,
+			[]bson.M{
+				{"title": nil, "_id": bson.M{"$gt": id}},
+				{"title": bson.M{"$ne": nil}},
+			},
+		},
+		{
+			"null key descending", null, -1, false,
+			[]bson.M{{"title": nil, "_id": bson.M{"$lt": id}}},
+		},
+		{
+			"null key before an ascending page", null, 1, true,
+			[]bson.M{{"title": nil, "_id": bson.M{"$lt": id}}},
+		},
+		{
+			"key ascending", title, 1, false,
+			[]bson.M{
+				{"title": bson.M{"$gt": title}},
+				{"title": title, "_id": bson.M{"$gt": id}},
+			},
+		},
+		{
+			// descending runs into the nulls once the set keys are done
+			"key descending", title, -1, false,
+			[]bson.M{
+				{"title": bson.M{"$lt": title}},
+				{"title": title, "_id": bson.M{"$lt": id}},
+				{"title": nil},
+			},
+		},
+		{
+			"key before a descending page", title, -1, true,
+			[]bson.M{
+				{"title": bson.M{"$gt": title}},
+				{"title": title, "_id": bson.M{"$gt": id}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := &Cursor{Sort: "title", Dir: tt.dir, Key: tt.key, ID: id, Before: tt.before}
+		got := c.Filter()
+		if want := (bson.M{"$or": tt.want}); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Filter = %v, want %v", tt.name, got, want)
+		}
+	}
+}
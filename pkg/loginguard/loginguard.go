@@ -0,0 +1,108 @@
+// Package loginguard slows down password guessing. Failed logins are counted
+// per key (an account or a client ip) and once a key has used up its free
+// attempts every further attempt has to wait an exponentially growing delay,
+// until enough failures lock the key out for a while. Counters live in a
+// Store - memory for a single instance, mongo when several share the load
+package loginguard
+
+import (
+	"context"
+	"time"
+)
+
+// State is what a store keeps for a key
+type State struct {
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"lastFailure"`
+}
+
+type Store interface {
+	// Get returns the state of a key - an unknown key has a zero state
+	Get(ctx context.Context, key string) (State, error)
+	// Reserve counts a failure at now - failures older than window are
+	// forgotten first - but only while the key still has the state seen. It
+	// reports false when the state changed since it was read
+	Reserve(ctx context.Context, key string, seen State, now time.Time, window time.Duration) (bool, error)
+	// Release takes back one failure of a key - the key is still forgotten a
+	// window after its last failure
+	Release(ctx context.Context, key string, window time.Duration) error
+	// Reset forgets a key
+	Reset(ctx context.Context, key string) error
+}
+
+// Policy decides how long a key waits after its failures
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay
+	FreeAttempts int
+	// BaseDelay doubles with every failure past FreeAttempts up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAfter failures lock the key for LockoutDuration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered without a new one
+	Window time.Duration
+}
+
+// Wait returns how long the key has to wait from now and whether that wait is
+// a lockout
+func (p Policy) Wait(s State, now time.Time) (time.Duration, bool) {
+	if s.Failures == 0 || now.Sub(s.LastFailure) > p.Window {
+		return 0, false
+	}
+	var until time.Time
+	locked := p.LockoutAfter > 0 && s.Failures >= p.LockoutAfter
+	if locked {
+		until = s.LastFailure.Add(p.LockoutDuration)
+	} else if s.Failures > p.FreeAttempts {
+		delay := p.BaseDelay
+		for i := p.FreeAttempts + 1; i < s.Failures && delay < p.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+		until = s.LastFailure.Add(delay)
+	}
+	if !now.Before(until) {
+		return 0, false
+	}
+	return until.Sub(now), locked
+}
+
+// Limiter applies a policy to the keys of a store
+type Limiter struct {
+	Store  Store
+	Policy Policy
+}
+
+// Attempt counts an attempt as a failure before it is made, so concurrent
+// attempts cannot all pass a check made before any of them failed - Reset or
+// Release the key once the attempt turns out well. It returns how long the key
+// has to wait when it has no attempt left for now
+func (l *Limiter) Attempt(ctx context.Context, key string) (time.Duration, bool, error) {
+	for {
+		now := time.Now()
+		s, err := l.Store.Get(ctx, key)
+		if err != nil {
+			return 0, false, err
+		}
+		if wait, locked := l.Policy.Wait(s, now); wait > 0 {
+			return wait, locked, nil
+		}
+		ok, err := l.Store.Reserve(ctx, key, s, now, l.Policy.Window)
+		if err != nil || ok {
+			return 0, false, err
+		}
+		// another attempt counted first - its failure may be the one that
+		// makes this one wait
+	}
+}
+
+func (l *Limiter) Release(ctx context.Context, key string) error {
+	return l.Store.Release(ctx, key, l.Policy.Window)
+}
+
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.Store.Reset(ctx, key)
+}
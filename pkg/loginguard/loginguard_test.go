@@ -0,0 +1,173 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyWait(t *testing.T) {
+	p := Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	last := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		failures   int
+		since      time.Duration
+		wantWait   time.Duration
+		wantLocked bool
+	}{
+		{"no failures", 0, 0, 0, false},
+		{"free attempts", 3, 0, 0, false},
+		{"first delay", 4, 0, time.Second, false},
+		{"delay doubles", 5, 0, 2 * time.Second, false},
+		{"delay doubles again", 6, 0, 4 * time.Second, false},
+		{"delay is capped", 9, 0, 10 * time.Second, false},
+		{"part of the delay has passed", 5, 500 * time.Millisecond, 1500 * time.Millisecond, false},
+		{"delay has passed", 5, 2 * time.Second, 0, false},
+		{"locked out", 10, 0, 15 * time.Minute, true},
+		{"lockout wears off", 10, 15 * time.Minute, 0, false},
+		{"partly served lockout", 12, 5 * time.Minute, 10 * time.Minute, true},
+		{"failures outside the window are forgotten", 50, time.Hour + time.Second, 0, false},
+	}
+	for _, tt := range tests {
+		s := State{Failures: tt.failures, LastFailure: last}
+		wait, locked := p.Wait(s, last.Add(tt.since))
+		if wait != tt.wantWait || locked != tt.wantLocked {
+			t.Errorf("%s: Wait = %v, %v, want %v, %v", tt.name, wait, locked, tt.wantWait, tt.wantLocked)
+		}
+	}
+}
+
+func TestPolicyWaitWithoutLockout(t *testing.T) {
+	p := Policy{FreeAttempts: 0, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	now := time.Now()
+	wait, locked := p.Wait(State{Failures: 1000, LastFailure: now}, now)
+	if wait != time.Minute || locked {
+		t.Errorf("Wait = %v, %v, want the max delay without a lockout", wait, locked)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		seen, _ := m.Get(ctx, "k")
+		if ok, err := m.Reserve(ctx, "k", seen, now, time.Minute); !ok || err != nil {
+			t.Fatalf("Reserve %d = %v, %v", i, ok, err)
+		}
+		if s, _ := m.Get(ctx, "k"); s.Failures != i {
+			t.Fatalf("failures after Reserve %d = %d", i, s.Failures)
+		}
+	}
+	// a reservation made on a state that has changed since is refused
+	if ok, _ := m.Reserve(ctx, "k", State{Failures: 2, LastFailure: now}, now, time.Minute); ok {
+		t.Error("Reserve on a stale state counted")
+	}
+	m.Release(ctx, "k", time.Minute)
+	if s, _ := m.Get(ctx, "k"); s.Failures != 2 {
+		t.Errorf("failures after Release = %d, want 2", s.Failures)
+	}
+	// a failure after the window starts the count again
+	later := now.Add(2 * time.Minute)
+	if ok, _ := m.Reserve(ctx, "k", State{}, later, time.Minute); !ok {
+		t.Fatal("Reserve after the window refused")
+	}
+	if s, _ := m.states.Get("k", later); s.Failures != 1 {
+		t.Errorf("failures after the window = %d, want 1", s.Failures)
+	}
+	if s, _ := m.Get(ctx, "other"); s.Failures != 0 {
+		t.Errorf("unknown key has %d failures", s.Failures)
+	}
+	m.Reset(ctx, "k")
+	if s, _ := m.Get(ctx, "k"); s.Failures != 0 {
+		t.Errorf("reset key has %d failures", s.Failures)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := &Limiter{Store: NewMemoryStore(), Policy: Policy{
+		FreeAttempts: 1,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}}
+	attempt := func(wantWait bool) {
+		t.Helper()
+		wait, _, err := l.Attempt(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (wait > 0) != wantWait {
+			t.Errorf("Attempt waits %v, want waiting %v", wait, wantWait)
+		}
+	}
+	attempt(false)
+	attempt(false)
+	attempt(true)
+	// an attempt that turned out well gives its failure back
+	l.Release(ctx, "k")
+	attempt(false)
+	attempt(true)
+	l.Reset(ctx, "k")
+	attempt(false)
+}
+
+// racingStore holds the first reads until all of them are made, so every
+// attempt sees the same state before any of them is counted
+type racingStore struct {
+	Store
+	first int64
+	read  sync.WaitGroup
+}
+
+func (r *racingStore) Get(ctx context.Context, key string) (State, error) {
+	s, err := r.Store.Get(ctx, key)
+	if atomic.AddInt64(&r.first, -1) >= 0 {
+		r.read.Done()
+		r.read.Wait()
+	}
+	return s, err
+}
+
+func TestLimiterConcurrentAttempts(t *testing.T) {
+	ctx := context.Background()
+	store := &racingStore{Store: NewMemoryStore(), first: 50}
+	store.read.Add(50)
+	l := &Limiter{Store: store, Policy: Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}}
+	var allowed int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, _, err := l.Attempt(ctx, "k")
+			if err != nil {
+				t.Error(err)
+			}
+			if wait == 0 {
+				atomic.AddInt64(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	// the free attempts and the one whose failure starts the backoff
+	if allowed != 4 {
+		t.Errorf("%d of 50 concurrent attempts were let through, want 4", allowed)
+	}
+}
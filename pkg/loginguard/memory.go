@@ -0,0 +1,58 @@
+package loginguard
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/expiring"
+	"time"
+)
+
+// sweepEvery is how many writes pass between sweeps of forgotten keys
+const sweepEvery = 1024
+
+// MemoryStore counts the failures seen by this instance only, so behind a
+// load balancer an attacker spread over the instances gets that many more
+// free attempts. Failures are forgotten a window after the last one
+type MemoryStore struct {
+	states *expiring.Map[State]
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: expiring.New[State](sweepEvery)}
+}
+
+func (m *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s, _ := m.states.Get(key, time.Now())
+	return s, nil
+}
+
+func (m *MemoryStore) Reserve(ctx context.Context, key string, seen State, now time.Time, window time.Duration) (bool, error) {
+	reserved := false
+	m.states.Update(key, now, func(s State, ok bool) (State, time.Time) {
+		if s.Failures != seen.Failures || !s.LastFailure.Equal(seen.LastFailure) {
+			return s, s.LastFailure.Add(window)
+		}
+		if now.Sub(s.LastFailure) > window {
+			s.Failures = 0
+		}
+		s.Failures++
+		s.LastFailure = now
+		reserved = true
+		return s, now.Add(window)
+	})
+	return reserved, nil
+}
+
+func (m *MemoryStore) Release(ctx context.Context, key string, window time.Duration) error {
+	m.states.Update(key, time.Now(), func(s State, ok bool) (State, time.Time) {
+		if s.Failures > 0 {
+			s.Failures--
+		}
+		return s, s.LastFailure.Add(window)
+	})
+	return nil
+}
+
+func (m *MemoryStore) Reset(ctx context.Context, key string) error {
+	m.states.Delete(key)
+	return nil
+}
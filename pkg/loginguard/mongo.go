@@ -0,0 +1,77 @@
+package loginguard
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoStore keeps the failures of a key in a document with the key as _id so
+// every instance of the api sees the same failures. A key is dropped by a ttl
+// index once its failures are older than the window
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoStore(coll *mongo.Collection) *MongoStore {
+	return &MongoStore{coll: coll}
+}
+
+func (m *MongoStore) Get(ctx context.Context, key string) (State, error) {
+	var s State
+	err := m.coll.FindOne(ctx, bson.M{"_id": key}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return State{}, nil
+	}
+	return s, err
+}
+
+// Reserve inserts a key seen without failures and otherwise counts the failure
+// in one pipeline update filtered on the state seen, so of several instances
+// racing on a key only one gets each attempt
+func (m *MongoStore) Reserve(ctx context.Context, key string, seen State, now time.Time, window time.Duration) (bool, error) {
+	if seen == (State{}) {
+		_, err := m.coll.InsertOne(ctx, bson.M{"_id": key, "failures": 1, "lastFailure": now, "expiresAt": now.Add(window)})
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	cutoff := now.Add(-window)
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$lastFailure", cutoff}}, cutoff}},
+			1,
+			bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+		}},
+		"lastFailure": now,
+		"expiresAt":   now.Add(window),
+	}}}}
+	filter := bson.M{"_id": key, "failures": seen.Failures, "lastFailure": seen.LastFailure}
+	result, err := m.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (m *MongoStore) Release(ctx context.Context, key string, window time.Duration) error {
+	_, err := m.coll.UpdateOne(ctx, bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}})
+	return err
+}
+
+// EnsureIndexes creates the ttl index that drops forgotten keys
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (m *MongoStore) Reset(ctx context.Context, key string) error {
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
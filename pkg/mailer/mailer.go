@@ -0,0 +1,96 @@
+// Package mailer sends the account emails of the api. The smtp mailer is used
+// in production, the file mailer writes every message to disk (or the log)
+// for local development and tests
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Message) error
+}
+
+// format renders a message as a plain text email
+func format(from string, m Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
+	b.WriteString(m.Body)
+	return []byte(b.String())
+}
+
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPMailer) Send(m Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, s.From, []string{m.To}, format(s.From, m))
+}
+
+// FileMailer writes each message to its own .eml file in Dir - with no Dir
+// the message is written to the log instead
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(m Message) error {
+	raw := format(f.From, m)
+	if f.Dir == "" {
+		log.Printf("mailer: message to %s\n%s", m.To, raw)
+		return nil
+	}
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(m.To))
+	return os.WriteFile(filepath.Join(f.Dir, name), raw, 0o644)
+}
+
+// FromEnv picks a mailer from the environment - MAILER=smtp uses the SMTP_*
+// settings, anything else writes messages to MAIL_DIR or the log
+func FromEnv() Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "helloBourbon <no-reply@hellobourbon.local>"
+	}
+	if os.Getenv("MAILER") == "smtp" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	}
+	return &FileMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
+}
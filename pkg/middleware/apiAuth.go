@@ -2,35 +2,120 @@ package middleware
 
 import (
 	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/apikey"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/cache"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/usage"
+	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
 	"net/http"
+	"time"
 )
 
 var keysCollection = db.GetCollection(db.DB, "keys")
 
-func ApiAuth(next http.Handler) http.Handler {
+// usageRecorder counts requests per key and writes them in batches, which
+// also keeps the last access time of each key
+var usageRecorder = usage.NewRecorder(
+	helpers.EnvDuration("USAGE_FLUSH_INTERVAL", 30*time.Second),
+	helpers.EnvInt("USAGE_MAX_PENDING", 5000),
+)
+
+// FlushUsage writes the key usage still buffered - the server calls it once
+// it has stopped taking requests
+func FlushUsage(ctx context.Context) error {
+	return usageRecorder.Close(ctx)
+}
+
+// ApiAuth lets through requests with an active api key that was granted the
+// scope
+func ApiAuth(next http.Handler, scope string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var er responses.ErrorResponse
-		q := r.URL.Query()
-		str := q.Get("apiKey")
-		key, kErr := primitive.ObjectIDFromHex(str)
-		if kErr != nil {
-			er.Respond(w, 400, "error", "invalid apikey")
+		str := apikey.FromRequest(r)
+		if str == "" {
+			er.Respond(w, 401, "error", "unauthorized - requires valid api key")
+			return
+		}
+		key, err := findKey(str)
+		if err == mongo.ErrNoDocuments {
+			er.Respond(w, 401, "error", "unauthorized - requires valid api key")
 			return
 		}
-		filter := bson.M{"_id": key, "active": true}
-		keyCount, err := keysCollection.CountDocuments(context.TODO(), filter)
 		if err != nil {
 			er.Respond(w, 500, "error", err.Error())
 			return
 		}
-		if keyCount != 1 {
-			er.Respond(w, 401, "error", "unauthorized - requires valid api key")
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			usageRecorder.Record(key.ID, routeTemplate(r), r.Method, sw.status, time.Now())
+		}()
+		if !key.Allows(scope) {
+			er.Respond(sw, 403, "error", "forbidden - api key is missing scope "+scope)
+			return
+		}
+		if !limitKey(sw, key) {
 			return
 		}
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), "apiKey", key)
+		next.ServeHTTP(sw, r.WithContext(ctx))
 	})
 }
+
+// keyTTL is how long a looked up key is cached - key admin writes clear the
+// cache, this bounds how long another instance keeps a revoked key
+var keyTTL = helpers.EnvDuration("CACHE_KEYS_TTL", time.Minute)
+
+// findKey looks up an active key by the hash of its secret. Legacy keys are
+// presented as their _id, which is only accepted while they have no hash -
+// the _id of a hashed key is shown in the admin list and is not a secret.
+// Only found keys are cached, by the hash of what was presented
+func findKey(str string) (*models.APIKey, error) {
+	hash := apikey.Hash(str)
+	var key models.APIKey
+	if cache.GetBSON(cache.Keys, hash, &key) {
+		return &key, nil
+	}
+	filter := bson.M{"keyHash": hash, "active": true}
+	if id, err := primitive.ObjectIDFromHex(str); err == nil {
+		filter = bson.M{"_id": id, "keyHash": bson.M{"$exists": false}, "active": true}
+	}
+	if err := keysCollection.FindOne(context.TODO(), filter).Decode(&key); err != nil {
+		return nil, err
+	}
+	if err := cache.SetBSON(cache.Keys, hash, &key, keyTTL); err != nil {
+		log.Printf("api key not cached: %v", err)
+	}
+	return &key, nil
+}
+
+// statusWriter remembers the status a handler responded with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wrote {
+		sw.status, sw.wrote = status, true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// routeTemplate is the path template of the matched route so usage of
+// /api/bourbons/{id} is counted once and not per bourbon
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"regexp"
+	"time"
 )
 
 var usersCollection = db.GetCollection(
@@ -56,9 +57,21 @@ func Auth(next http.Handler) http.Handler {
 				return
 			}
 			var userId string
+			var sessionId string
 			claims, claimOk := token.Claims.(jwt.MapClaims)
 			if claimOk && token.Valid {
+				// tokens issued before sessions have no expiry and are refused
+				if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+					er.Respond(w, 401, "error", "unauthorized - token expired")
+					return
+				}
 				userId = claims["UserId"].(string)
+				sessionId, _ = claims["sid"].(string)
+			}
+			// tokens issued before sessions carry no session id
+			if sessionId == "" {
+				er.Respond(w, 401, "error", "unauthorized - session revoked")
+				return
 			}
 			userIdAsPrimitive, iErr := primitive.ObjectIDFromHex(userId)
 			if iErr != nil {
@@ -74,13 +87,54 @@ func Auth(next http.Handler) http.Handler {
 				er.Respond(w, 500, "error", uErr.Error())
 				return
 			}
+			// the user is loaded for the context anyway so checking the session
+			// list on it costs nothing and sees revocations made by any instance
+			if user.Session(sessionId) == nil {
+				er.Respond(w, 401, "error", "unauthorized - session revoked")
+				return
+			}
+			touchSession(&user, sessionId)
 			authContext := models.AuthContext{
-				UserId:   userIdAsPrimitive,
-				Username: user.Username,
-				Token:    tokenString,
+				UserId:    userIdAsPrimitive,
+				Username:  user.Username,
+				Token:     tokenString,
+				SessionID: sessionId,
+				IsAdmin:   user.IsAdmin,
 			}
 			ctx := context.WithValue(r.Context(), "authContext", &authContext)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		},
 	)
 }
+
+// sessionTouchInterval limits how often the last used time of a session is
+// written while it is being used
+const sessionTouchInterval = time.Minute
+
+// touchSession moves the last used time of a session forward
+func touchSession(user *models.User, sessionId string) {
+	session := user.Session(sessionId)
+	now := time.Now()
+	if session == nil || now.Sub(session.LastUsedAt.Time()) < sessionTouchInterval {
+		return
+	}
+	filter := bson.M{"_id": user.ID, "tokens.sessionId": sessionId}
+	update := bson.M{"$set": bson.M{"tokens.$.lastUsedAt": primitive.NewDateTimeFromTime(now)}}
+	usersCollection.UpdateOne(context.TODO(), filter, update)
+}
+
+// Admin must run after Auth - it only lets through users flagged as admins
+// on their user document
+func Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var er responses.ErrorResponse
+			authContext, ok := r.Context().Value("authContext").(*models.AuthContext)
+			if !ok || !authContext.IsAdmin {
+				er.Respond(w, 403, "error", "forbidden - admin only")
+				return
+			}
+			next.ServeHTTP(w, r)
+		},
+	)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/ratelimit"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// keyLimiter meters requests per api key - RATE_LIMIT_STORE=mongo shares the
+// counters between instances
+var keyLimiter = &ratelimit.Limiter{Store: newRateLimitStore()}
+
+func newRateLimitStore() ratelimit.Store {
+	if os.Getenv("RATE_LIMIT_STORE") == "mongo" {
+		return ratelimit.NewMongoStore(db.GetCollection(db.DB, "rate_limits"))
+	}
+	return ratelimit.NewMemoryStore()
+}
+
+// EnsureIndexes creates the ttl index of the rate limit counters when they
+// are kept in mongo
+func EnsureIndexes(ctx context.Context) error {
+	if ms, ok := keyLimiter.Store.(*ratelimit.MongoStore); ok {
+		return ms.EnsureIndexes(ctx)
+	}
+	return nil
+}
+
+// default limits for keys without their own
+var defaultLimits = models.RateLimits{
+	PerSecond: helpers.EnvInt("API_RATE_PER_SECOND", 10),
+	PerMinute: helpers.EnvInt("API_RATE_PER_MINUTE", 300),
+	Daily:     helpers.EnvInt("API_DAILY_QUOTA", 50000),
+}
+
+// pick returns the limit of a key over the default - negative turns it off
+func pick(own, def int) int {
+	if own < 0 {
+		return 0
+	}
+	if own > 0 {
+		return own
+	}
+	return def
+}
+
+func keyLimits(key *models.APIKey) ratelimit.Limits {
+	own := models.RateLimits{}
+	if key.Limits != nil {
+		own = *key.Limits
+	}
+	return ratelimit.Limits{
+		Buckets: []ratelimit.Bucket{
+			{Limit: pick(own.PerSecond, defaultLimits.PerSecond), Window: time.Second},
+			{Limit: pick(own.PerMinute, defaultLimits.PerMinute), Window: time.Minute},
+		},
+		Daily: pick(own.Daily, defaultLimits.Daily),
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// limitKey meters a request of the key and writes the RateLimit headers. It
+// responds 429 and returns false when the key is over a limit - a failing
+// store lets the request through rather than locking every client out
+func limitKey(w http.ResponseWriter, key *models.APIKey) bool {
+	d, err := keyLimiter.Allow(context.TODO(), key.ID.Hex(), keyLimits(key), time.Now())
+	if err != nil {
+		log.Printf("rate limit check failed: %v", err)
+		return true
+	}
+	if d.Policy == "" {
+		return true
+	}
+	h := w.Header()
+	h.Set("RateLimit-Policy", d.Policy)
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("RateLimit-Reset", seconds(d.Reset))
+	if d.Allowed {
+		return true
+	}
+	retry := seconds(d.RetryAfter)
+	h.Set("Retry-After", retry)
+	var er responses.ErrorResponse
+	er.Respond(w, 429, "error", fmt.Sprintf("rate limit exceeded - try again in %s seconds", retry))
+	return false
+}
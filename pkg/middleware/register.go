@@ -4,31 +4,51 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/handlers"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/responses"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/validation"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
 	"io/ioutil"
 	"net/http"
-	"net/mail"
+	"strings"
 )
 
-func isValidEmail(e string) bool {
-	_, err := mail.ParseAddress(e)
-	return err == nil
-}
-
-func isExistingUser(e string) bool {
-	filter := bson.M{"email": e}
-	var existingUser models.User
-	err := usersCollection.FindOne(context.TODO(), filter).Decode(&existingUser)
-	return err == nil
-}
-
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	return string(bytes), err
+// validateRegistration checks every field of a registration and collects the
+// problems per field - uniqueness is only checked for fields that are valid
+func validateRegistration(req *models.RegisterUserRequest) (validation.FieldErrors, error) {
+	errs := validation.FieldErrors{}
+	req.Username = strings.TrimSpace(req.Username)
+	if msg := validation.CheckUsername(req.Username); msg != "" {
+		errs.Add("username", msg)
+	}
+	email, ok := validation.NormalizeEmail(req.Email)
+	if !ok {
+		errs.Add("email", "must be a valid email address")
+	}
+	req.Email = email
+	if msg := validation.Passwords.Check(req.Password); msg != "" {
+		errs.Add("password", msg)
+	}
+	if !errs.Has("username") {
+		taken, err := handlers.UsernameTaken(context.TODO(), req.Username, primitive.NilObjectID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			errs.Add("username", "is already taken")
+		}
+	}
+	if !errs.Has("email") {
+		taken, err := handlers.EmailTaken(context.TODO(), req.Email, primitive.NilObjectID)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			errs.Add("email", "is already registered")
+		}
+	}
+	return errs, nil
 }
 
 func Register(next http.Handler) http.Handler {
@@ -43,19 +63,17 @@ func Register(next http.Handler) http.Handler {
 				er.Respond(w, 400, "error", err.Error())
 				return
 			}
-			// check if the request had empty body
-			if reqResult.Email == "" || reqResult.Password == "" {
-				er.Respond(w, 400, "error", err.Error())
+			fieldErrs, vErr := validateRegistration(&reqResult)
+			if vErr != nil {
+				er.Respond(w, 500, "error", vErr.Error())
 				return
 			}
-			validEmail := isValidEmail(reqResult.Email)
-			alreadyExists := isExistingUser(reqResult.Email)
-			if !validEmail || alreadyExists {
-				er.Respond(w, 400, "error", "user or email invalid")
+			if !fieldErrs.Empty() {
+				er.Respond(w, 400, "error", fieldErrs)
 				return
 			}
 			// hash new user password from request
-			hashed, hashErr := hashPassword(reqResult.Password)
+			hashed, hashErr := helpers.HashPassword(reqResult.Password)
 			if hashErr != nil {
 				er.Respond(w, 500, "error", hashErr.Error())
 				return
@@ -63,13 +81,8 @@ func Register(next http.Handler) http.Handler {
 			var newUser models.User
 			// generate our user primitive object Id
 			uid := primitive.NewObjectID()
-			// generate JWT
-			token, tErr := handlers.GenerateAuthToken(uid.Hex())
-			if tErr != nil {
-				er.Respond(w, 500, "error", tErr.Error())
-				return
-			}
-			newUser.Build(uid, reqResult.Username, reqResult.Email, hashed, token)
+			// the first session and its tokens are created by the handler
+			newUser.Build(uid, reqResult.Username, reqResult.Email, hashed)
 			ctx := context.WithValue(r.Context(), "user", &newUser)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		},
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// account token purposes
+const (
+	TokenPasswordReset = "password_reset"
+	TokenEmailVerify   = "email_verify"
+)
+
+// AccountToken is a single use token mailed to a user - only the hash of the
+// token is stored. Email is the address a verify token was sent to so a token
+// cannot verify an address the user has since changed
+type AccountToken struct {
+	ID        primitive.ObjectID `bson:"_id" json:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Purpose   string             `bson:"purpose" json:"purpose"`
+	Hash      string             `bson:"hash" json:"-"`
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	CreatedAt primitive.DateTime `bson:"createdAt" json:"createdAt"`
+	ExpiresAt primitive.DateTime `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    primitive.DateTime `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+}
+
+func (t *AccountToken) Build(uId primitive.ObjectID, purpose, hash, email string, ttl time.Duration) {
+	t.ID = primitive.NewObjectID()
+	t.UserID = uId
+	t.Purpose = purpose
+	t.Hash = hash
+	t.Email = email
+	t.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+	t.ExpiresAt = primitive.NewDateTimeFromTime(time.Now().Add(ttl))
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
@@ -2,19 +2,91 @@ package models
 
 import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
 	"time"
 )
 
+// CollectionShareLink is an unguessable token the owner of a private collection
+// or wishlist can hand out - anyone holding the token can read the list until
+// the owner revokes it
+type CollectionShareLink struct {
+	Token     string             `bson:"token" json:"token"`
+	CreatedAt primitive.DateTime `bson:"createdAt" json:"createdAt"`
+}
+
+// member roles - the owner of a collection is always Collection.User but
+// additional owners can be granted through membership
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// member statuses - an invited member has no access until they accept
+const (
+	MemberInvited  = "invited"
+	MemberAccepted = "accepted"
+)
+
+// CollectionMember is a user other than the owner that has been invited to
+// a collection or wishlist with a role
+type CollectionMember struct {
+	User      *UserRef           `bson:"user" json:"user"`
+	Role      string             `bson:"role" json:"role"`
+	Status    string             `bson:"status" json:"status"`
+	InvitedAt primitive.DateTime `bson:"invitedAt" json:"invitedAt"`
+	JoinedAt  primitive.DateTime `bson:"joinedAt,omitempty" json:"joinedAt,omitempty"`
+}
+
+// CollectionBourbon is a bourbon as it sits in a collection or wishlist - a copy
+// of the catalog bourbon plus what the user keeps about their own bottle. The
+// position of an entry in Collection.Bourbons is the users custom order
+type CollectionBourbon struct {
+	Bourbon     `bson:",inline"`
+	Tags        []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	Notes       string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	PricePaid   float64            `bson:"pricePaid,omitempty" json:"pricePaid,omitempty"`
+	PurchasedAt primitive.DateTime `bson:"purchasedAt,omitempty" json:"purchasedAt,omitempty"`
+	AddedAt     primitive.DateTime `bson:"addedAt,omitempty" json:"addedAt,omitempty"`
+}
+
+func NewCollectionBourbon(b Bourbon) *CollectionBourbon {
+	return &CollectionBourbon{
+		Bourbon: b,
+		AddedAt: primitive.NewDateTimeFromTime(time.Now()),
+	}
+}
+
+// HasTags reports whether the entry carries every one of the tags - tags
+// compare without case
+func (e *CollectionBourbon) HasTags(tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, et := range e.Tags {
+			if strings.EqualFold(et, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // Collection struct should work for both the bourbon collection and the bourbon wishlist
 // data base collection types
 type Collection struct {
-	ID        primitive.ObjectID `bson:"_id" json:"_id"`
-	User      *UserRef           `bson:"user" json:"user"`
-	Name      string             `bson:"name" json:"name"`
-	Private   bool               `bson:"private" json:"private"`
-	Bourbons  []*Bourbon         `bson:"bourbons" json:"bourbons"`
-	CreatedAt primitive.DateTime `bson:"createdAt" json:"createdAt"`
-	UpdatedAt primitive.DateTime `bson:"updatedAt" json:"updatedAt"`
+	ID         primitive.ObjectID     `bson:"_id" json:"_id"`
+	User       *UserRef               `bson:"user" json:"user"`
+	Name       string                 `bson:"name" json:"name"`
+	Private    bool                   `bson:"private" json:"private"`
+	Bourbons   []*CollectionBourbon   `bson:"bourbons" json:"bourbons"`
+	Members    []*CollectionMember    `bson:"members,omitempty" json:"members,omitempty"`
+	ShareLinks []*CollectionShareLink `bson:"share_links,omitempty" json:"share_links,omitempty"`
+	CreatedAt  primitive.DateTime     `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  primitive.DateTime     `bson:"updatedAt" json:"updatedAt"`
 }
 
 func (c *Collection) Build(uId primitive.ObjectID, un, n string, p bool) {
@@ -25,7 +97,40 @@ func (c *Collection) Build(uId primitive.ObjectID, un, n string, p bool) {
 	}
 	c.Name = n
 	c.Private = p
-	c.Bourbons = make([]*Bourbon, 0)
+	c.Bourbons = make([]*CollectionBourbon, 0)
 	c.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	c.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 }
+
+// HideFromNonOwner strips what only owners may see before a collection is
+// returned to anyone else - the share links and the members with their
+// roles and pending invites
+func (c *Collection) HideFromNonOwner() {
+	c.ShareLinks = nil
+	c.Members = nil
+}
+
+// ViewFor hides what only owners may see unless the user is an owner
+func (c *Collection) ViewFor(uId primitive.ObjectID) {
+	if c.RoleFor(uId) != RoleOwner {
+		c.HideFromNonOwner()
+	}
+}
+
+// RoleFor returns the role a user holds on the collection - an empty string
+// means the user is neither the owner nor an accepted member
+func (c *Collection) RoleFor(uId primitive.ObjectID) string {
+	if c.User != nil && c.User.ID == uId {
+		return RoleOwner
+	}
+	for _, m := range c.Members {
+		if m.User != nil && m.User.ID == uId && m.Status == MemberAccepted {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+func IsValidMemberRole(role string) bool {
+	return role == RoleOwner || role == RoleEditor || role == RoleViewer
+}
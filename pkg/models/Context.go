@@ -3,7 +3,9 @@ package models
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type AuthContext struct {
-	UserId   primitive.ObjectID
-	Username string
-	Token    string
+	UserId    primitive.ObjectID
+	Username  string
+	Token     string
+	SessionID string
+	IsAdmin   bool
 }
@@ -2,10 +2,65 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// APIKey identifies a client app. Keys created through the admin endpoints
+// only store the hash of their secret - keys made by hand before that have no
+// hash and are presented as the hex of their _id
 type APIKey struct {
-	ID         primitive.ObjectID `bson:"_id"`
-	AppName    string             `bson:"app_name"`
-	Active     bool               `bson:"active"`
-	CreatedAt  primitive.DateTime `bson:"createdAt"`
-	LastAccess primitive.DateTime `bson:"lastAccess"`
+	ID         primitive.ObjectID `bson:"_id" json:"id"`
+	AppName    string             `bson:"app_name" json:"app_name"`
+	Active     bool               `bson:"active" json:"active"`
+	Prefix     string             `bson:"prefix,omitempty" json:"prefix,omitempty"`
+	KeyHash    string             `bson:"keyHash,omitempty" json:"-"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy,omitempty" json:"created_by,omitempty"`
+	CreatedAt  primitive.DateTime `bson:"createdAt" json:"created_at"`
+	RotatedAt  primitive.DateTime `bson:"rotatedAt,omitempty" json:"rotated_at,omitempty"`
+	RevokedAt  primitive.DateTime `bson:"revokedAt,omitempty" json:"revoked_at,omitempty"`
+	LastAccess primitive.DateTime `bson:"lastAccess" json:"last_access"`
+	Limits     *RateLimits        `bson:"limits,omitempty" json:"limits,omitempty"`
+	Scopes     []string           `bson:"scopes,omitempty" json:"scopes"`
+}
+
+// RateLimits override the default limits for a key - a zero field keeps the
+// default and a negative one turns that limit off
+type RateLimits struct {
+	PerSecond int `bson:"perSecond,omitempty" json:"per_second,omitempty"`
+	PerMinute int `bson:"perMinute,omitempty" json:"per_minute,omitempty"`
+	Daily     int `bson:"daily,omitempty" json:"daily,omitempty"`
+}
+
+// Legacy reports whether the key predates hashed secrets
+func (k *APIKey) Legacy() bool {
+	return k.KeyHash == ""
+}
+
+// Allows reports whether the key was granted the scope - a key without
+// scopes is allowed nothing
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyRequest struct {
+	AppName string      `json:"app_name"`
+	Limits  *RateLimits `json:"limits"`
+	Scopes  []string    `json:"scopes"`
+}
+
+type UpdateAPIKeyScopesRequest struct {
+	Scopes []string `json:"scopes"`
+}
+
+// KeyUsage counts the requests one key made to one route with one status in
+// one hour
+type KeyUsage struct {
+	KeyID  primitive.ObjectID `bson:"keyId" json:"key_id"`
+	Hour   primitive.DateTime `bson:"hour" json:"hour"`
+	Route  string             `bson:"route" json:"route"`
+	Method string             `bson:"method" json:"method"`
+	Status int                `bson:"status" json:"status"`
+	Count  int                `bson:"count" json:"count"`
 }
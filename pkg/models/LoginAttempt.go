@@ -0,0 +1,38 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// login attempt outcomes
+const (
+	LoginSuccess        = "success"
+	LoginBadCredentials = "bad_credentials"
+	LoginThrottled      = "throttled"
+	LoginLocked         = "locked"
+	// the password was right and a 2fa code is now needed
+	LoginTwoFactorRequired = "two_factor_required"
+	LoginBadTwoFactor      = "bad_two_factor"
+)
+
+// LoginAttempt is one entry of the login audit trail - UserID is only set
+// when the email belonged to a user
+type LoginAttempt struct {
+	ID        primitive.ObjectID  `bson:"_id" json:"_id"`
+	UserID    *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email     string              `bson:"email" json:"email"`
+	IP        string              `bson:"ip" json:"ip"`
+	UserAgent string              `bson:"userAgent" json:"userAgent"`
+	Outcome   string              `bson:"outcome" json:"outcome"`
+	CreatedAt primitive.DateTime  `bson:"createdAt" json:"createdAt"`
+}
+
+func (a *LoginAttempt) Build(email, ip, ua, outcome string) {
+	a.ID = primitive.NewObjectID()
+	a.Email = email
+	a.IP = ip
+	a.UserAgent = ua
+	a.Outcome = outcome
+	a.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+}
@@ -7,6 +7,20 @@ type UserRef struct {
 	Username string             `bson:"username" json:"username"`
 }
 
+// DeletedUsername stands in for the author of reviews left behind by a
+// deleted account
+const DeletedUsername = "[deleted]"
+
+// TombstoneUserRef is the user ref a review keeps once its author has deleted
+// their account - the nil object id never matches a real user
+func TombstoneUserRef() *UserRef {
+	return &UserRef{ID: primitive.NilObjectID, Username: DeletedUsername}
+}
+
+func (u *UserRef) IsTombstone() bool {
+	return u.ID.IsZero()
+}
+
 type BourbonsRef struct {
 	BourbonID primitive.ObjectID `bson:"bourbon_id" json:"bourbon_id"`
 }
@@ -26,8 +40,43 @@ func (c *CollectionRequest) FillDefaults() {
 	}
 }
 
+// BulkBourbonRequest carries many add/delete operations against the bourbons
+// of one collection or wishlist
+type BulkBourbonRequest struct {
+	Operations []*BulkBourbonOperation `json:"operations"`
+}
+
+type BulkBourbonOperation struct {
+	BourbonID string `json:"bourbon_id"`
+	Action    string `json:"action"`
+}
+
+// ImportConfirmRequest fills a new collection or wishlist with the rows of an
+// import preview the user confirmed - Date is read like a csv date so the
+// dates of the preview can be sent back as they are
+type ImportConfirmRequest struct {
+	Type    string              `json:"type"`
+	Name    string              `json:"name"`
+	Private bool                `json:"private"`
+	Rows    []*ImportConfirmRow `json:"rows"`
+}
+
+type ImportConfirmRow struct {
+	BourbonID string   `json:"bourbon_id"`
+	PricePaid *float64 `json:"price_paid"`
+	Date      string   `json:"date"`
+}
+
 type ReviewRequest struct {
 	ReviewTitle string `json:"reviewTitle"`
 	ReviewScore string `json:"reviewScore"`
 	ReviewText  string `json:"reviewText"`
 }
+
+// DeleteAccountRequest re-authenticates a user before their account is deleted
+// like ChangeEmailRequest - reviews are anonymized unless DeleteReviews is set
+type DeleteAccountRequest struct {
+	Password      string `json:"password"`
+	Code          string `json:"code"`
+	DeleteReviews bool   `json:"delete_reviews"`
+}
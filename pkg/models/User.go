@@ -34,24 +34,79 @@ func (u *UserWishlistRef) Build(wId primitive.ObjectID, n string) {
 	u.Bourbons = make([]*BourbonsRef, 0)
 }
 
+// UserTokenRef is one login session of a user. The access token is a short
+// lived jwt carrying the SessionID - only the hash of the current refresh
+// token is kept, along with a few rotated hashes so a replayed refresh token
+// can be spotted. Token is only set on sessions from before refresh tokens
 type UserTokenRef struct {
-	Token string `bson:"token" json:"token"`
+	Token         string             `bson:"token,omitempty" json:"-"`
+	SessionID     string             `bson:"sessionId,omitempty" json:"session_id"`
+	RefreshHash   string             `bson:"refreshHash,omitempty" json:"-"`
+	RotatedHashes []string           `bson:"rotatedHashes,omitempty" json:"-"`
+	Device        string             `bson:"device,omitempty" json:"device"`
+	IP            string             `bson:"ip,omitempty" json:"ip"`
+	CreatedAt     primitive.DateTime `bson:"createdAt,omitempty" json:"created_at"`
+	LastUsedAt    primitive.DateTime `bson:"lastUsedAt,omitempty" json:"last_used_at"`
+	ExpiresAt     primitive.DateTime `bson:"expiresAt,omitempty" json:"expires_at"`
+}
+
+// Expired reports whether the refresh token of the session can no longer be used
+func (t *UserTokenRef) Expired(now time.Time) bool {
+	return t.ExpiresAt != 0 && t.ExpiresAt.Time().Before(now)
+}
+
+// Session returns the session with the id, nil when there is none
+func (u *User) Session(sId string) *UserTokenRef {
+	for _, t := range u.Tokens {
+		if t.SessionID != "" && t.SessionID == sId {
+			return t
+		}
+	}
+	return nil
+}
+
+// UserTOTP is the two factor setup of a user. PendingSecret is set between
+// enroll and confirm, Secret once 2fa is on. Recovery codes are stored hashed
+// and LastStep is the time step of the last accepted code so none is reused
+type UserTOTP struct {
+	Enabled       bool               `bson:"enabled"`
+	Secret        string             `bson:"secret,omitempty"`
+	PendingSecret string             `bson:"pendingSecret,omitempty"`
+	RecoveryCodes []string           `bson:"recoveryCodes,omitempty"`
+	LastStep      int64              `bson:"lastStep,omitempty"`
+	EnabledAt     primitive.DateTime `bson:"enabledAt,omitempty"`
+}
+
+// HasPassword reports whether the user knows their password - users created
+// through a provider have a random one until they set their own with a reset
+func (u *User) HasPassword() bool {
+	return !u.NoPassword
+}
+
+// TwoFactorEnabled reports whether logins need a second step
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTP != nil && u.TOTP.Enabled
 }
 
 type User struct {
-	ID          primitive.ObjectID   `bson:"_id" json:"_id"`
-	Username    string               `bson:"username" json:"username"`
-	Email       string               `bson:"email" json:"email"`
-	Password    string               `bson:"password" json:"-"`
-	Collections []*UserCollectionRef `bson:"collections" json:"collections"`
-	Reviews     []*UserReviewRef     `bson:"reviews" json:"reviews"`
-	Wishlists   []*UserWishlistRef   `bson:"wishlists" json:"wishlists"`
-	Tokens      []*UserTokenRef      `bson:"tokens" json:"-"`
-	CreatedAt   primitive.DateTime   `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   primitive.DateTime   `bson:"updatedAt" json:"updatedAt"`
-}
-
-func (u *User) Build(i primitive.ObjectID, n, e, hp, t string) {
+	ID            primitive.ObjectID   `bson:"_id" json:"_id"`
+	Username      string               `bson:"username" json:"username"`
+	Email         string               `bson:"email" json:"email"`
+	EmailVerified bool                 `bson:"emailVerified" json:"emailVerified"`
+	Password      string               `bson:"password" json:"-"`
+	NoPassword    bool                 `bson:"noPassword,omitempty" json:"noPassword,omitempty"`
+	Collections   []*UserCollectionRef `bson:"collections" json:"collections"`
+	Reviews       []*UserReviewRef     `bson:"reviews" json:"reviews"`
+	Wishlists     []*UserWishlistRef   `bson:"wishlists" json:"wishlists"`
+	Tokens        []*UserTokenRef      `bson:"tokens" json:"-"`
+	IsAdmin       bool                 `bson:"isAdmin,omitempty" json:"isAdmin,omitempty"`
+	TOTP          *UserTOTP            `bson:"totp,omitempty" json:"-"`
+	Identities    []*UserIdentity      `bson:"identities,omitempty" json:"identities,omitempty"`
+	CreatedAt     primitive.DateTime   `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     primitive.DateTime   `bson:"updatedAt" json:"updatedAt"`
+}
+
+func (u *User) Build(i primitive.ObjectID, n, e, hp string) {
 	u.ID = i
 	u.Username = n
 	u.Email = e
@@ -59,9 +114,7 @@ func (u *User) Build(i primitive.ObjectID, n, e, hp, t string) {
 	u.Collections = make([]*UserCollectionRef, 0)
 	u.Reviews = make([]*UserReviewRef, 0)
 	u.Wishlists = make([]*UserWishlistRef, 0)
-	u.Tokens = append(u.Tokens, &UserTokenRef{
-		Token: t,
-	})
+	u.Tokens = make([]*UserTokenRef, 0)
 	u.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
 	u.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 }
@@ -71,3 +124,59 @@ type RegisterUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 }
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangeEmailRequest re-authenticates with the password, or for users without
+// one with a two factor code when they did not log in just now
+type ChangeEmailRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+	Email    string `json:"email"`
+}
+
+type ChangeUsernameRequest struct {
+	Username string `json:"username"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TwoFactorLoginRequest completes a login with the challenge token from the
+// first step and either a totp code or a recovery code
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// UserIdentity links a user to an account at an external oidc provider -
+// Subject is the stable id the provider gives the user
+type UserIdentity struct {
+	Provider string             `bson:"provider" json:"provider"`
+	Subject  string             `bson:"subject" json:"subject"`
+	Email    string             `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt primitive.DateTime `bson:"linkedAt" json:"linkedAt"`
+}
+
+// OIDCState is kept between sending a user to a provider and the callback -
+// State is the _id so each one can only be used once
+type OIDCState struct {
+	State        string             `bson:"_id"`
+	Provider     string             `bson:"provider"`
+	Nonce        string             `bson:"nonce"`
+	CodeVerifier string             `bson:"codeVerifier"`
+	ExpiresAt    primitive.DateTime `bson:"expiresAt"`
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/expiring"
+	"time"
+)
+
+// sweepEvery is how many writes pass between sweeps of idle keys
+const sweepEvery = 4096
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore meters the keys seen by this instance only, so behind a load
+// balancer every instance allows the full limits. A bucket is forgotten once
+// it has been left alone for a window - it would be full again by then - and
+// a daily count at the end of its day
+type MemoryStore struct {
+	buckets  *expiring.Map[bucketState]
+	counters *expiring.Map[int]
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  expiring.New[bucketState](sweepEvery),
+		counters: expiring.New[int](sweepEvery),
+	}
+}
+
+func (m *MemoryStore) Peek(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, error) {
+	b, ok := m.buckets.Get(key, now)
+	if !ok {
+		return float64(limit), nil
+	}
+	return refill(b.tokens, b.last, limit, window, now), nil
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, bool, error) {
+	taken := false
+	b := m.buckets.Update(key, now, func(b bucketState, ok bool) (bucketState, time.Time) {
+		if !ok {
+			b = bucketState{tokens: float64(limit), last: now}
+		}
+		if now.After(b.last) {
+			b.tokens = refill(b.tokens, b.last, limit, window, now)
+			b.last = now
+		}
+		if b.tokens >= 1 {
+			b.tokens--
+			taken = true
+		}
+		return b, now.Add(window)
+	})
+	return b.tokens, taken, nil
+}
+
+func (m *MemoryStore) Incr(ctx context.Context, key string, until time.Time, now time.Time) (int, error) {
+	return m.counters.Update(key, now, func(count int, ok bool) (int, time.Time) {
+		return count + 1, until
+	}), nil
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// MongoStore keeps each bucket and daily count in a document with the key as
+// _id so every instance of the api meters the same buckets. Documents carry
+// an expiresAt that the index from EnsureIndexes drops them by
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+func NewMongoStore(coll *mongo.Collection) *MongoStore {
+	return &MongoStore{coll: coll}
+}
+
+// EnsureIndexes creates the ttl index that drops idle buckets and past days
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (m *MongoStore) Peek(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, error) {
+	var s struct {
+		Tokens float64   `bson:"tokens"`
+		Last   time.Time `bson:"last"`
+	}
+	err := m.coll.FindOne(ctx, bson.M{"_id": key}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return float64(limit), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return refill(s.Tokens, s.Last, limit, window, now), nil
+}
+
+// Take refills and takes in one pipeline update so concurrent requests from
+// several instances never take the same token
+func (m *MongoStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, bool, error) {
+	perMs := float64(limit) / float64(window.Milliseconds())
+	refilled := bson.M{"$min": bson.A{
+		limit,
+		bson.M{"$add": bson.A{
+			bson.M{"$ifNull": bson.A{"$tokens", limit}},
+			bson.M{"$multiply": bson.A{
+				bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$last", now}}}},
+				perMs,
+			}},
+		}},
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refilled, "last": now, "expiresAt": now.Add(window)}}},
+		{{Key: "$set", Value: bson.M{
+			"taken": bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{
+				bson.M{"$gte": bson.A{"$tokens", 1}},
+				bson.M{"$subtract": bson.A{"$tokens", 1}},
+				"$tokens",
+			}},
+		}}},
+	}
+	var s struct {
+		Tokens float64 `bson:"tokens"`
+		Taken  bool    `bson:"taken"`
+	}
+	err := m.upsert(ctx, key, update, &s)
+	return s.Tokens, s.Taken, err
+}
+
+func (m *MongoStore) Incr(ctx context.Context, key string, until time.Time, now time.Time) (int, error) {
+	update := bson.M{
+		"$inc":         bson.M{"count": 1},
+		"$setOnInsert": bson.M{"expiresAt": until},
+	}
+	var s struct {
+		Count int `bson:"count"`
+	}
+	err := m.upsert(ctx, key, update, &s)
+	return s.Count, err
+}
+
+// upsert runs the update and decodes the result - two instances inserting a
+// new key at once make one of them fail on the _id, so that one runs again
+// against the inserted document
+func (m *MongoStore) upsert(ctx context.Context, key string, update interface{}, v interface{}) error {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := m.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(v)
+	if mongo.IsDuplicateKeyError(err) {
+		err = m.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(v)
+	}
+	return err
+}
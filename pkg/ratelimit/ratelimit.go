@@ -0,0 +1,156 @@
+// Package ratelimit meters requests per client key. Short bursts are limited
+// with token buckets - a bucket holds Limit tokens and refills them evenly
+// over its Window - and long term use with a quota that resets every UTC day.
+// Counters live in a Store - memory for a single instance, mongo when several
+// share the load
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Store interface {
+	// Peek returns the tokens in the bucket of a key as of now without
+	// taking one - an unknown key has a full bucket
+	Peek(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, error)
+	// Take removes a token from the bucket of a key if it has one, refilling
+	// it for the time since the last take first. It returns the tokens left
+	// and whether one was taken - an unknown key starts with a full bucket
+	Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (float64, bool, error)
+	// Incr counts a use of a key at now and returns the new count - the count
+	// is forgotten after until
+	Incr(ctx context.Context, key string, until time.Time, now time.Time) (int, error)
+}
+
+// Bucket is a token bucket rule - a Limit of zero turns it off
+type Bucket struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Limits are the rules for one client - a Daily quota of zero turns it off
+type Limits struct {
+	Buckets []Bucket
+	Daily   int
+}
+
+// Decision is the outcome of a request with the numbers for the RateLimit
+// headers - they describe whichever rule is closest to running out
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+	Policy     string
+}
+
+type Limiter struct {
+	Store Store
+}
+
+// Allow meters one request of a key. Every bucket is checked before a token
+// is taken from any, so a request one bucket refuses costs the others
+// nothing, and a request the buckets refuse does not count against the daily
+// quota
+func (l *Limiter) Allow(ctx context.Context, key string, limits Limits, now time.Time) (Decision, error) {
+	d := Decision{Allowed: true, Remaining: math.MaxInt32, Policy: limits.policy()}
+	var buckets []Bucket
+	for _, b := range limits.Buckets {
+		if b.Limit > 0 && b.Window > 0 {
+			buckets = append(buckets, b)
+		}
+	}
+	for _, b := range buckets {
+		left, err := l.Store.Peek(ctx, bucketKey(key, b), b.Limit, b.Window, now)
+		if err != nil {
+			return d, err
+		}
+		if left < 1 {
+			d.refuse(b, left)
+			return d, nil
+		}
+	}
+	for _, b := range buckets {
+		left, ok, err := l.Store.Take(ctx, bucketKey(key, b), b.Limit, b.Window, now)
+		if err != nil {
+			return d, err
+		}
+		// a concurrent request can empty a bucket between the check and the take
+		if !ok {
+			d.refuse(b, left)
+			return d, nil
+		}
+		d.considerBucket(b, left)
+	}
+	if limits.Daily > 0 {
+		day := now.UTC().Truncate(24 * time.Hour)
+		reset := day.Add(24 * time.Hour)
+		used, err := l.Store.Incr(ctx, key+":day:"+day.Format("2006-01-02"), reset, now)
+		if err != nil {
+			return d, err
+		}
+		remaining := limits.Daily - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		d.consider(limits.Daily, remaining, reset.Sub(now))
+		if used > limits.Daily {
+			d.Allowed = false
+			d.RetryAfter = reset.Sub(now)
+		}
+	}
+	return d, nil
+}
+
+// considerBucket considers a bucket with left tokens - it is reset once full
+func (d *Decision) considerBucket(b Bucket, left float64) {
+	perToken := b.Window / time.Duration(b.Limit)
+	d.consider(b.Limit, int(left), time.Duration((float64(b.Limit)-left)*float64(perToken)))
+}
+
+// refuse refuses the request until the bucket has a whole token again
+func (d *Decision) refuse(b Bucket, left float64) {
+	d.considerBucket(b, left)
+	d.Allowed = false
+	d.RetryAfter = time.Duration((1 - left) * float64(b.Window/time.Duration(b.Limit)))
+}
+
+// refill returns the tokens of a bucket at now - it gains limit tokens evenly
+// over every window and never holds more than limit
+func refill(tokens float64, last time.Time, limit int, window time.Duration, now time.Time) float64 {
+	elapsed := now.Sub(last)
+	if elapsed <= 0 {
+		return tokens
+	}
+	return math.Min(float64(limit), tokens+float64(limit)*elapsed.Seconds()/window.Seconds())
+}
+
+// consider makes the rule the one reported when it has fewer requests left
+func (d *Decision) consider(limit, remaining int, reset time.Duration) {
+	if remaining < d.Remaining || (remaining == d.Remaining && reset > d.Reset) {
+		d.Limit, d.Remaining, d.Reset = limit, remaining, reset
+	}
+}
+
+// policy describes the rules in RateLimit-Policy form, e.g. 10;w=1, 5000;w=86400
+func (l Limits) policy() string {
+	var parts []string
+	for _, b := range l.Buckets {
+		if b.Limit > 0 && b.Window > 0 {
+			parts = append(parts, fmt.Sprintf("%d;w=%d", b.Limit, int(b.Window.Seconds())))
+		}
+	}
+	if l.Daily > 0 {
+		parts = append(parts, fmt.Sprintf("%d;w=%d", l.Daily, 24*60*60))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func bucketKey(key string, b Bucket) string {
+	return fmt.Sprintf("%s:%d/%s", key, b.Limit, b.Window)
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitsPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   string
+	}{
+		{"everything", Limits{Buckets: []Bucket{{10, time.Second}, {300, time.Minute}}, Daily: 5000}, "10;w=1, 300;w=60, 5000;w=86400"},
+		{"buckets turned off", Limits{Buckets: []Bucket{{0, time.Second}, {300, time.Minute}}}, "300;w=60"},
+		{"nothing", Limits{}, ""},
+	}
+	for _, tt := range tests {
+		if got := tt.limits.policy(); got != tt.want {
+			t.Errorf("%s: policy = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRefill(t *testing.T) {
+	last := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		tokens float64
+		since  time.Duration
+		want   float64
+	}{
+		{"no time passed", 2, 0, 2},
+		{"clock went back", 2, -time.Second, 2},
+		{"half a window", 0, 30 * time.Second, 5},
+		{"capped at the limit", 8, time.Minute, 10},
+	}
+	for _, tt := range tests {
+		if got := refill(tt.tokens, last, 10, time.Minute, last.Add(tt.since)); got != tt.want {
+			t.Errorf("%s: refill = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLimiterBuckets(t *testing.T) {
+	ctx := context.Background()
+	l := &Limiter{Store: NewMemoryStore()}
+	limits := Limits{Buckets: []Bucket{{Limit: 2, Window: time.Second}, {Limit: 3, Window: time.Minute}}}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		at            time.Duration
+		wantAllowed   bool
+		wantLimit     int
+		wantRemaining int
+	}{
+		{"first", 0, true, 2, 1},
+		{"second", 0, true, 2, 0},
+		{"per second bucket empty", 0, false, 2, 0},
+		{"per second bucket refilled", time.Second, true, 3, 0},
+		{"per minute bucket empty", 2 * time.Second, false, 3, 0},
+		{"still empty", 3 * time.Second, false, 3, 0},
+	}
+	for _, tt := range tests {
+		d, err := l.Allow(ctx, "k", limits, now.Add(tt.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed != tt.wantAllowed || d.Limit != tt.wantLimit || d.Remaining != tt.wantRemaining {
+			t.Errorf("%s: Allow = allowed %v, %d of %d, want allowed %v, %d of %d",
+				tt.name, d.Allowed, d.Remaining, d.Limit, tt.wantAllowed, tt.wantRemaining, tt.wantLimit)
+		}
+		if !d.Allowed && d.RetryAfter <= 0 {
+			t.Errorf("%s: refused without a Retry-After", tt.name)
+		}
+	}
+}
+
+func TestLimiterRefusalTakesNothing(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStore()
+	l := &Limiter{Store: m}
+	perSecond := Bucket{Limit: 5, Window: time.Second}
+	perMinute := Bucket{Limit: 1, Window: time.Minute}
+	limits := Limits{Buckets: []Bucket{perSecond, perMinute}, Daily: 10}
+	now := time.Now()
+	if d, _ := l.Allow(ctx, "k", limits, now); !d.Allowed {
+		t.Fatal("first request refused")
+	}
+	for i := 0; i < 3; i++ {
+		if d, _ := l.Allow(ctx, "k", limits, now); d.Allowed {
+			t.Fatal("per minute bucket did not refuse")
+		}
+	}
+	// the refusals of the per minute bucket must not drain the per second one
+	left, _ := m.Peek(ctx, bucketKey("k", perSecond), perSecond.Limit, perSecond.Window, now)
+	if left != 4 {
+		t.Errorf("per second bucket has %v tokens, want 4", left)
+	}
+	// nor count against the daily quota - this use makes it 2
+	used, _ := m.Incr(ctx, "k:day:"+now.UTC().Format("2006-01-02"), now.Add(time.Hour), now)
+	if used != 2 {
+		t.Errorf("daily count = %d, want 2", used)
+	}
+}
+
+func TestLimiterDaily(t *testing.T) {
+	ctx := context.Background()
+	l := &Limiter{Store: NewMemoryStore()}
+	limits := Limits{Daily: 2}
+	day := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	for i, want := range []bool{true, true, false} {
+		d, err := l.Allow(ctx, "k", limits, day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed != want {
+			t.Errorf("request %d: allowed %v, want %v", i+1, d.Allowed, want)
+		}
+		if !d.Allowed && d.RetryAfter != time.Hour {
+			t.Errorf("request %d: Retry-After %v, want the hour until midnight", i+1, d.RetryAfter)
+		}
+	}
+	if d, _ := l.Allow(ctx, "k", limits, day.Add(2*time.Hour)); !d.Allowed {
+		t.Error("quota did not reset the next day")
+	}
+}
@@ -0,0 +1,65 @@
+package responses
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag returns a strong entity tag for a value - the sha256 of its json, so
+// it changes whenever anything a client would see changes
+func ETag(v interface{}) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagListed reports whether the etag is in a header list of etags - weak
+// tags never match as only strong etags are handed out
+func etagListed(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, against the current validators
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListed(inm, etag)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.Truncate(time.Second).After(ims)
+}
+
+// IfMatch reports whether an update may go ahead - true when the request has
+// no If-Match or lists the current etag of the resource
+func IfMatch(r *http.Request, etag string) bool {
+	im := r.Header.Get("If-Match")
+	return im == "" || etagListed(im, etag)
+}
+
+// RespondConditional responds like Respond with a 200 and the ETag and
+// Last-Modified of d, or with an empty 304 when the client already has that
+// version. A zero lastModified leaves the header out
+func (r StandardResponse) RespondConditional(w http.ResponseWriter, req *http.Request, lastModified time.Time, m string, d interface{}) {
+	etag := ETag(d)
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(req, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	r.Respond(w, 200, m, d)
+}
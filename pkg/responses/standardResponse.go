@@ -2,7 +2,9 @@ package responses
 
 import (
 	"encoding/json"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/importer"
 	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http"
 )
 
@@ -23,8 +25,62 @@ func (r StandardResponse) Respond(w http.ResponseWriter, status int, m string, d
 // user responses
 
 type UserTokenResponse struct {
-	User  *models.User `json:"user"`
-	Token string       `json:"token"`
+	User         *models.User       `json:"user,omitempty"`
+	Token        string             `json:"token"`
+	ExpiresAt    primitive.DateTime `json:"expires_at"`
+	RefreshToken string             `json:"refresh_token"`
+	SessionID    string             `json:"session_id"`
+}
+
+// TwoFactorChallengeResponse is the first step of a login with 2fa on - the
+// challenge token is sent back with a code to finish the login
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool               `json:"two_factor_required"`
+	ChallengeToken    string             `json:"challenge_token"`
+	ExpiresAt         primitive.DateTime `json:"expires_at"`
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// RecoveryCodesResponse carries recovery codes - they are only ever shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type OIDCProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// OIDCStartResponse carries the url to send the browser to - state comes back
+// on the callback
+type OIDCStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+type SessionResponse struct {
+	*models.UserTokenRef
+	Current bool `json:"current"`
+}
+
+type SessionsResponse struct {
+	Sessions []*SessionResponse `json:"sessions"`
+}
+
+// api key responses
+
+// APIKeyResponse carries a key and, when it was just created or rotated, its
+// secret - the secret is only ever shown once
+type APIKeyResponse struct {
+	Key    *models.APIKey `json:"key"`
+	Secret string         `json:"secret,omitempty"`
+}
+
+type APIKeysResponse struct {
+	Keys []*models.APIKey `json:"keys"`
 }
 
 // bourbon responses
@@ -36,6 +92,10 @@ type SingleBourbonResponse struct {
 type BourbonsResponse struct {
 	Bourbons     []*models.Bourbon `json:"bourbons"`
 	TotalRecords int               `json:"total_records"`
+	Next         string            `json:"next,omitempty"`
+	Prev         string            `json:"prev,omitempty"`
+	NextCursor   string            `json:"next_cursor,omitempty"`
+	PrevCursor   string            `json:"prev_cursor,omitempty"`
 }
 
 // collection responses
@@ -46,7 +106,8 @@ type CollectionResponse struct {
 }
 
 type CollectionsResponse struct {
-	Collections []*models.Collection `json:"collections"`
+	Collections  []*models.Collection `json:"collections"`
+	TotalRecords int                  `json:"total_records,omitempty"`
 }
 
 // wishlist responses
@@ -57,7 +118,60 @@ type WishlistResponse struct {
 }
 
 type WishlistsResponse struct {
-	Wishlists []*models.Collection `json:"wishlists"`
+	Wishlists    []*models.Collection `json:"wishlists"`
+	TotalRecords int                  `json:"total_records,omitempty"`
+}
+
+// share link responses
+
+type ShareLinkResponse struct {
+	CollectionID string                      `json:"collection_id"`
+	ShareLink    *models.CollectionShareLink `json:"share_link"`
+}
+
+// bulk bourbon responses - one result per requested operation in request order
+
+type BulkItemResult struct {
+	BourbonID string `json:"bourbon_id"`
+	Action    string `json:"action"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+}
+
+type BulkBourbonsResponse struct {
+	Collection     *models.Collection        `json:"collection,omitempty"`
+	UserCollection *models.UserCollectionRef `json:"user_collection,omitempty"`
+	Wishlist       *models.Collection        `json:"wishlist,omitempty"`
+	UserWishlist   *models.UserWishlistRef   `json:"user_wishlist,omitempty"`
+	Applied        int                       `json:"applied"`
+	Skipped        int                       `json:"skipped"`
+	Failed         int                       `json:"failed"`
+	Results        []*BulkItemResult         `json:"results"`
+}
+
+// import responses
+
+type ImportPreviewRow struct {
+	*importer.Row
+	Matched      bool                  `json:"matched"`
+	Match        *importer.Candidate   `json:"match,omitempty"`
+	Alternatives []*importer.Candidate `json:"alternatives,omitempty"`
+}
+
+type ImportPreviewResponse struct {
+	Rows      []*ImportPreviewRow `json:"rows"`
+	Total     int                 `json:"total"`
+	Matched   int                 `json:"matched"`
+	Unmatched []*importer.Row     `json:"unmatched"`
+}
+
+// acquire responses - a bourbon moved from a wishlist into a collection
+
+type AcquireResponse struct {
+	Collection     *models.Collection        `json:"collection"`
+	UserCollection *models.UserCollectionRef `json:"user_collection"`
+	Wishlist       *models.Collection        `json:"wishlist"`
+	UserWishlist   *models.UserWishlistRef   `json:"user_wishlist"`
 }
 
 // review responses
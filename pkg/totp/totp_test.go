@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the ascii seed "12345678901234567890" of the RFC 6238 sha1 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 appendix B lists 8 digit codes - these are their last 6 digits
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeAt(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != v.code {
+			t.Errorf("CodeAt(t=%d) = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestCodeAtLowercaseSecret(t *testing.T) {
+	got, err := CodeAt(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("CodeAt with a lowercase secret = %q, %v", got, err)
+	}
+}
+
+func TestCodeAtBadSecret(t *testing.T) {
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt accepted a secret that is not base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(s int64) string {
+		c, err := CodeAt(rfcSecret, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		ok       bool
+		wantStep int64
+	}{
+		{"current step", code(step), true, step},
+		{"previous step", code(step - 1), true, step - 1},
+		{"next step", code(step + 1), true, step + 1},
+		{"two steps back", code(step - 2), false, 0},
+		{"two steps ahead", code(step + 2), false, 0},
+		{"spaces are ignored", " " + code(step)[:3] + " " + code(step)[3:], true, step},
+		{"too short", code(step)[:5], false, 0},
+		{"wrong code", "000000", false, 0},
+		{"empty", "", false, 0},
+	}
+	for _, tt := range tests {
+		got, ok := Validate(rfcSecret, tt.code, now)
+		if ok != tt.ok || got != tt.wantStep {
+			t.Errorf("%s: Validate = %d, %v, want %d, %v", tt.name, got, ok, tt.wantStep, tt.ok)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	s, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 32 {
+		t.Errorf("secret %q is %d chars, want 32", s, len(s))
+	}
+	if _, err := CodeAt(s, 1); err != nil {
+		t.Errorf("generated secret does not decode: %v", err)
+	}
+}
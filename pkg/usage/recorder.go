@@ -0,0 +1,150 @@
+// Package usage records which routes each api key calls. Requests are counted
+// in memory and flushed in batches into hourly counters per key, route and
+// status, and a report sums the counters over a time range
+package usage
+
+import (
+	"context"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	usageCollection = db.GetCollection(db.DB, "key_usage")
+	keysCollection  = db.GetCollection(db.DB, "keys")
+)
+
+// hit is one counter - the hour is truncated so a counter covers every
+// request of the key to the route with the status in that hour
+type hit struct {
+	keyID  primitive.ObjectID
+	hour   time.Time
+	route  string
+	method string
+	status int
+}
+
+// Recorder buffers counts until the next flush, which happens every interval
+// or as soon as maxPending counters are waiting. Close writes what is left on
+// shutdown - counts still buffered when the process is killed are lost
+type Recorder struct {
+	mu         sync.Mutex
+	counts     map[hit]int
+	lastAccess map[primitive.ObjectID]time.Time
+	maxPending int
+	kick       chan struct{}
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+func NewRecorder(interval time.Duration, maxPending int) *Recorder {
+	rec := &Recorder{
+		counts:     make(map[hit]int),
+		lastAccess: make(map[primitive.ObjectID]time.Time),
+		maxPending: maxPending,
+		kick:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	go rec.loop(interval)
+	return rec
+}
+
+// Record counts a request of a key - it never touches the database
+func (rec *Recorder) Record(keyID primitive.ObjectID, route, method string, status int, at time.Time) {
+	h := hit{keyID: keyID, hour: at.UTC().Truncate(time.Hour), route: route, method: method, status: status}
+	rec.mu.Lock()
+	rec.counts[h]++
+	if at.After(rec.lastAccess[keyID]) {
+		rec.lastAccess[keyID] = at
+	}
+	full := len(rec.counts) >= rec.maxPending
+	rec.mu.Unlock()
+	if full {
+		select {
+		case rec.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (rec *Recorder) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-rec.kick:
+		case <-rec.stop:
+			return
+		}
+		if err := rec.Flush(context.TODO()); err != nil {
+			log.Printf("key usage flush failed: %v", err)
+		}
+	}
+}
+
+// Flush writes the buffered counts and last access times. Counts that could
+// not be written go back into the buffer for the next flush
+func (rec *Recorder) Flush(ctx context.Context) error {
+	rec.mu.Lock()
+	counts, lastAccess := rec.counts, rec.lastAccess
+	rec.counts = make(map[hit]int)
+	rec.lastAccess = make(map[primitive.ObjectID]time.Time)
+	rec.mu.Unlock()
+	if len(counts) == 0 {
+		return nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(counts))
+	for h, n := range counts {
+		filter := bson.M{"keyId": h.keyID, "hour": h.hour, "route": h.route, "method": h.method, "status": h.status}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$inc": bson.M{"count": n}}).
+			SetUpsert(true))
+	}
+	opts := options.BulkWrite().SetOrdered(false)
+	if _, err := usageCollection.BulkWrite(ctx, writes, opts); err != nil {
+		rec.restore(counts, lastAccess)
+		return err
+	}
+	keyWrites := make([]mongo.WriteModel, 0, len(lastAccess))
+	for id, at := range lastAccess {
+		keyWrites = append(keyWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$max": bson.M{"lastAccess": primitive.NewDateTimeFromTime(at)}}))
+	}
+	// the counts are written by now - only lastAccess is kept for the next flush
+	if _, err := keysCollection.BulkWrite(ctx, keyWrites, opts); err != nil {
+		rec.restore(nil, lastAccess)
+		return err
+	}
+	return nil
+}
+
+// Close stops the flush loop and writes the counts still buffered
+func (rec *Recorder) Close(ctx context.Context) error {
+	rec.stopOnce.Do(func() { close(rec.stop) })
+	return rec.Flush(ctx)
+}
+
+// restore puts counts from a failed flush back - an unordered bulk write may
+// have applied some of them, so a retry can count those twice, which is
+// preferred over dropping a whole batch
+func (rec *Recorder) restore(counts map[hit]int, lastAccess map[primitive.ObjectID]time.Time) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	for h, n := range counts {
+		rec.counts[h] += n
+	}
+	for id, at := range lastAccess {
+		if at.After(rec.lastAccess[id]) {
+			rec.lastAccess[id] = at
+		}
+	}
+}
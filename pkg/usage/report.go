@@ -0,0 +1,126 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"time"
+)
+
+// Query selects the counters a report sums
+type Query struct {
+	// KeyID limits the report to one key when it is set
+	KeyID primitive.ObjectID
+	From  time.Time
+	To    time.Time
+	// Hourly keeps the hours apart instead of summing the whole range
+	Hourly bool
+}
+
+type Row struct {
+	KeyID   primitive.ObjectID `bson:"keyId" json:"key_id"`
+	AppName string             `bson:"-" json:"app_name"`
+	Hour    *time.Time         `bson:"hour,omitempty" json:"hour,omitempty"`
+	Route   string             `bson:"route" json:"route"`
+	Method  string             `bson:"method" json:"method"`
+	Status  int                `bson:"status" json:"status"`
+	Count   int                `bson:"count" json:"count"`
+}
+
+type Report struct {
+	From  time.Time `json:"from"`
+	To    time.Time `json:"to"`
+	Total int       `json:"total"`
+	Rows  []*Row    `json:"rows"`
+}
+
+// Run sums the counters of the query per key, route, method and status - the
+// busiest first
+func Run(ctx context.Context, q Query) (*Report, error) {
+	match := bson.M{"hour": bson.M{
+		"$gte": primitive.NewDateTimeFromTime(q.From.UTC().Truncate(time.Hour)),
+		"$lt":  primitive.NewDateTimeFromTime(q.To),
+	}}
+	if !q.KeyID.IsZero() {
+		match["keyId"] = q.KeyID
+	}
+	group := bson.M{"keyId": "$keyId", "route": "$route", "method": "$method", "status": "$status"}
+	if q.Hourly {
+		group["hour"] = "$hour"
+	}
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": bson.M{"_id": group, "count": bson.M{"$sum": "$count"}}},
+		{"$replaceRoot": bson.M{"newRoot": bson.M{"$mergeObjects": bson.A{"$_id", bson.M{"count": "$count"}}}}},
+		{"$sort": bson.D{{Key: "keyId", Value: 1}, {Key: "hour", Value: 1}, {Key: "count", Value: -1}, {Key: "route", Value: 1}}},
+	}
+	cursor, err := usageCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]*Row, 0)
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	report := &Report{From: q.From, To: q.To, Rows: rows}
+	names, err := appNames(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		row.AppName = names[row.KeyID]
+		report.Total += row.Count
+	}
+	return report, nil
+}
+
+func appNames(ctx context.Context, rows []*Row) (map[primitive.ObjectID]string, error) {
+	ids := make([]primitive.ObjectID, 0)
+	seen := make(map[primitive.ObjectID]bool)
+	for _, row := range rows {
+		if !seen[row.KeyID] {
+			seen[row.KeyID] = true
+			ids = append(ids, row.KeyID)
+		}
+	}
+	names := make(map[primitive.ObjectID]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	cursor, err := keysCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var keys []*models.APIKey
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		names[k.ID] = k.AppName
+	}
+	return names, nil
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "key usage report - %s to %s\n", r.From.Format(time.RFC3339), r.To.Format(time.RFC3339))
+	fmt.Fprintf(w, "%d requests\n", r.Total)
+	var current primitive.ObjectID
+	for _, row := range r.Rows {
+		if row.KeyID != current {
+			current = row.KeyID
+			name := row.AppName
+			if name == "" {
+				name = "(deleted key)"
+			}
+			fmt.Fprintf(w, "\n%s %s\n", name, row.KeyID.Hex())
+		}
+		hour := ""
+		if row.Hour != nil {
+			hour = row.Hour.UTC().Format("2006-01-02 15:00") + "  "
+		}
+		fmt.Fprintf(w, "  %s%-7s %-48s %d  %d\n", hour, row.Method, row.Route, row.Status, row.Count)
+	}
+}
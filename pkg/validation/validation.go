@@ -0,0 +1,141 @@
+// Package validation checks the account fields users choose - usernames,
+// emails and passwords - and collects the problems per field
+package validation
+
+import (
+	"fmt"
+	"github.com/GoloisaNinja/go-bourbon-api/pkg/helpers"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// FieldErrors maps a request field to what is wrong with it - it is sent back
+// as the data of a 400 so clients can show each message next to its field
+type FieldErrors map[string]string
+
+// Add keeps the first problem found for a field
+func (f FieldErrors) Add(field, msg string) {
+	if _, ok := f[field]; !ok {
+		f[field] = msg
+	}
+}
+
+func (f FieldErrors) Has(field string) bool {
+	_, ok := f[field]
+	return ok
+}
+
+func (f FieldErrors) Empty() bool {
+	return len(f) == 0
+}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{2,29}$`)
+
+// CheckUsername returns what is wrong with a username, an empty string when
+// nothing is. Uniqueness is checked against the database by the caller
+func CheckUsername(u string) string {
+	if u == "" {
+		return "is required"
+	}
+	if !usernamePattern.MatchString(u) {
+		return "must be 3 to 30 letters, digits, dots, dashes or underscores and start with a letter or digit"
+	}
+	return ""
+}
+
+// NormalizeEmail trims and lowercases an email - ok is false when it is not a
+// bare address
+func NormalizeEmail(e string) (string, bool) {
+	e = strings.TrimSpace(e)
+	addr, err := mail.ParseAddress(e)
+	if err != nil || addr.Address != e {
+		return "", false
+	}
+	return strings.ToLower(e), true
+}
+
+// PasswordPolicy is the strength a new password must meet
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireLetter bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+}
+
+// PolicyFromEnv reads the PASSWORD_* settings - bcrypt only uses the first 72
+// bytes so that is the longest password allowed
+func PolicyFromEnv() PasswordPolicy {
+	p := PasswordPolicy{
+		MinLength:     helpers.EnvInt("PASSWORD_MIN_LENGTH", 8),
+		MaxLength:     72,
+		RequireLetter: envBool("PASSWORD_REQUIRE_LETTER", true),
+		RequireUpper:  envBool("PASSWORD_REQUIRE_UPPER", false),
+		RequireDigit:  envBool("PASSWORD_REQUIRE_DIGIT", true),
+		RequireSymbol: envBool("PASSWORD_REQUIRE_SYMBOL", false),
+	}
+	if p.MinLength > p.MaxLength {
+		p.MinLength = p.MaxLength
+	}
+	return p
+}
+
+func envBool(key string, def bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	return def
+}
+
+// Check returns what is wrong with a password, an empty string when nothing is
+func (p PasswordPolicy) Check(pw string) string {
+	if pw == "" {
+		return "is required"
+	}
+	if len(pw) < p.MinLength {
+		return fmt.Sprintf("must be at least %d characters", p.MinLength)
+	}
+	if len(pw) > p.MaxLength {
+		return fmt.Sprintf("must be at most %d bytes", p.MaxLength)
+	}
+	var letter, upper, digit, symbol bool
+	for _, r := range pw {
+		switch {
+		case unicode.IsUpper(r):
+			upper, letter = true, true
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	missing := make([]string, 0)
+	if p.RequireLetter && !letter {
+		missing = append(missing, "a letter")
+	}
+	if p.RequireUpper && !upper {
+		missing = append(missing, "an uppercase letter")
+	}
+	if p.RequireDigit && !digit {
+		missing = append(missing, "a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		missing = append(missing, "a symbol")
+	}
+	if len(missing) > 0 {
+		return "must contain " + strings.Join(missing, ", ")
+	}
+	return ""
+}
+
+// Passwords is the policy every new password is checked against
+var Passwords = PolicyFromEnv()
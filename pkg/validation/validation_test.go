@@ -0,0 +1,50 @@
+package validation
+
+import "testing"
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	def := PasswordPolicy{MinLength: 8, MaxLength: 72, RequireLetter: true, RequireDigit: true}
+	strict := PasswordPolicy{MinLength: 10, MaxLength: 72, RequireLetter: true, RequireUpper: true, RequireDigit: true, RequireSymbol: true}
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+		pw     string
+		want   string
+	}{
+		{"empty", def, "", "is required"},
+		{"too short", def, "abc123", "must be at least 8 characters"},
+		{"too long", def, "a1" + string(make([]byte, 71)), "must be at most 72 bytes"},
+		{"no digit", def, "abcdefgh", "must contain a digit"},
+		{"no letter", def, "12345678", "must contain a letter"},
+		{"ok", def, "abcdefg1", ""},
+		{"uppercase counts as a letter", def, "ABCDEFG1", ""},
+		{"non ascii letters count", def, "éèêëàâä1", ""},
+		{"strict lists everything missing", strict, "abcdefghij", "must contain an uppercase letter, a digit, a symbol"},
+		{"strict ok", strict, "Abcdefgh1!", ""},
+		{"length is in bytes", PasswordPolicy{MinLength: 1, MaxLength: 4}, "ééé", "must be at most 4 bytes"},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.Check(tt.pw); got != tt.want {
+			t.Errorf("%s: Check(%q) = %q, want %q", tt.name, tt.pw, got, tt.want)
+		}
+	}
+}
+
+func TestCheckUsername(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"bourbonfan", true},
+		{"b_f.n-1", true},
+		{"ab", false},
+		{"_leading", false},
+		{"has space", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := CheckUsername(tt.in); (got == "") != tt.ok {
+			t.Errorf("CheckUsername(%q) = %q, want ok %v", tt.in, got, tt.ok)
+		}
+	}
+}